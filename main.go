@@ -2,28 +2,115 @@ package main
 
 import (
 	"flag"
+	"github.com/jolestar/go-probe/pkg/probe"
 	"github.com/jolestar/go-probe/pkg/web"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var (
-	listen string
+	configPath       string
+	listen           string
+	probeTimeout     time.Duration
+	probeConcurrency int
+	redact           bool
+	redactDeny       string
+	redactAllow      string
 )
 
 func init() {
+	flag.StringVar(&configPath, "config", "", "YAML config file, reloaded on SIGHUP")
 	flag.StringVar(&listen, "listen", ":80", "Address to listen to (TCP)")
+	flag.DurationVar(&probeTimeout, "probe-timeout", probe.DefaultTimeout, "Deadline of a single probe, 0 to disable")
+	flag.IntVar(&probeConcurrency, "probe-concurrency", probe.DefaultConcurrency, "Max probes run in parallel")
+	flag.BoolVar(&redact, "redact", true, "Mask secrets such as passwords, tokens and auth headers in results")
+	flag.StringVar(&redactDeny, "redact-deny", "", "Comma separated key patterns to mask besides the builtin ones, e.g. *_DSN")
+	flag.StringVar(&redactAllow, "redact-allow", "", "Comma separated key patterns never masked, e.g. KEYCLOAK_URL")
 }
 
 func main() {
 	flag.Parse()
 	log.Print("Starting go-probe")
-	config := &web.Config{Listen: listen}
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal(err.Error())
+		os.Exit(-1)
+	}
 	probe, err := web.New(config)
 	if err != nil {
 		log.Fatal(err.Error())
 		os.Exit(-1)
 	}
+	go reloadOnSignal(probe)
+	done := make(chan struct{})
+	go shutdownOnSignal(probe, done)
 	probe.Init()
-	probe.Serve()
+	if err := probe.Serve(); err != nil {
+		log.Fatal(err.Error())
+	}
+	<-done
+}
+
+// loadConfig reads the config file and the environment, flags given on the
+// command line take precedence over both.
+func loadConfig() (*web.Config, error) {
+	config, err := web.LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			config.Listen = listen
+		case "probe-timeout":
+			config.Probes.Timeout = probeTimeout
+		case "probe-concurrency":
+			config.Probes.Concurrency = probeConcurrency
+		case "redact":
+			config.Redaction.Enabled = redact
+		case "redact-deny":
+			config.Redaction.Deny = web.SplitList(redactDeny)
+		case "redact-allow":
+			config.Redaction.Allow = web.SplitList(redactAllow)
+		}
+	})
+	return config, nil
+}
+
+// reloadOnSignal reloads the config on SIGHUP, keeping the current one when
+// the new one is invalid. The listener stays open meanwhile.
+func reloadOnSignal(frame *web.Frame) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		config, err := loadConfig()
+		if err == nil {
+			err = frame.Reload(config)
+		}
+		if err != nil {
+			log.Printf("Reload config failed, keeping the current one: %s\n", err.Error())
+			continue
+		}
+		log.Print("Config reloaded")
+	}
+}
+
+// shutdownOnSignal shuts the frame down gracefully on SIGTERM or SIGINT and
+// closes done once the requests in flight are over. A second signal kills
+// the process right away.
+func shutdownOnSignal(frame *web.Frame, done chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	signal.Reset(syscall.SIGTERM, syscall.SIGINT)
+	log.Printf("Received %s, shutting down\n", sig)
+	if err := frame.Shutdown(); err != nil {
+		log.Printf("Shutdown did not finish: %s\n", err.Error())
+	} else {
+		log.Print("Shutdown complete")
+	}
+	close(done)
 }
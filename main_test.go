@@ -0,0 +1,21 @@
+package main
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/jolestar/go-probe/pkg/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbeTimeoutDisabled(t *testing.T) {
+	assert.NoError(t, flag.CommandLine.Parse([]string{"-probe-timeout", "0"}))
+	config, err := loadConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, time.Duration(0), config.Probes.Timeout)
+	_, err = web.New(config)
+	assert.NoError(t, err)
+}
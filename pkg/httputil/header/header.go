@@ -180,15 +180,26 @@ loop:
 			}
 			spec.Q = 1.0
 			s = skipSpace(s)
-			if strings.HasPrefix(s, ";") {
+			for strings.HasPrefix(s, ";") {
 				s = skipSpace(s[1:])
-				if !strings.HasPrefix(s, "q=") {
-					continue loop
-				}
-				spec.Q, s = expectQuality(s[2:])
-				if spec.Q < 0.0 {
-					continue loop
+				if strings.HasPrefix(s, "q=") {
+					spec.Q, s = expectQuality(s[2:])
+					if spec.Q < 0.0 {
+						continue loop
+					}
+				} else {
+					// Skip media type parameters such as version=1.0.0.
+					var pkey, pvalue string
+					pkey, s = expectToken(s)
+					if pkey == "" || !strings.HasPrefix(s, "=") {
+						continue loop
+					}
+					pvalue, s = expectTokenOrQuoted(s[1:])
+					if pvalue == "" {
+						continue loop
+					}
 				}
+				s = skipSpace(s)
 			}
 			specs = append(specs, spec)
 			s = skipSpace(s)
@@ -0,0 +1,373 @@
+package probe
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/docker"
+)
+
+const (
+	// cgroupUnlimited is reported for limits which are not set.
+	cgroupUnlimited = "max"
+	// userHZ is the unit of cpuacct.stat, fixed at 100 on Linux.
+	userHZ = 100
+)
+
+// cgroupFS locates the cgroup of the probe's own process. Both paths are
+// fields so tests can point them to a fake tree.
+type cgroupFS struct {
+	procCgroup string
+	root       string
+}
+
+// CgroupFunc reports the CPU, cpuset, memory, pids and IO limits and usage of
+// the cgroup go-probe runs in, which for a container are what it really gets
+// as opposed to the host wide numbers of memory-info and cpu-info.
+func CgroupFunc(_ context.Context) (*Result, error) {
+	fs := cgroupFS{procCgroup: hostProc("self", "cgroup"), root: hostSys("fs", "cgroup")}
+	return fs.probe()
+}
+
+func (fs cgroupFS) probe() (*Result, error) {
+	paths, err := fs.paths()
+	if err != nil {
+		return nil, err
+	}
+	result := NewResult("cgroup")
+	if _, err := os.Stat(filepath.Join(fs.root, "cgroup.controllers")); err == nil {
+		fs.probeV2(result, paths[""])
+	} else {
+		fs.probeV1(result, paths)
+	}
+	result.AddUnit("cpu.UsageSeconds", UnitSeconds)
+	result.AddUnit("cpu.ThrottledSeconds", UnitSeconds)
+	result.AddUnit("cpu.PeriodMicros", "microseconds")
+	result.AddUnit("cpu.QuotaMicros", "microseconds")
+	for _, key := range []string{"Limit", "Usage", "MaxUsage", "SwapLimit", "Cache", "RSS", "MappedFile"} {
+		result.AddUnit("memory."+key, UnitBytes)
+	}
+	result.AddUnit("memory.UsedPercent", UnitPercent)
+	for _, path := range []string{"cpu.UsageSeconds", "cpu.UserSeconds", "cpu.SystemSeconds", "cpu.ThrottledSeconds",
+		"cpu.NrThrottled", "memory.FailCnt", "memory.OOMKills", "memory.OOMEvents", "memory.PgMajFault"} {
+		result.AddCounter(path)
+	}
+	for _, key := range []string{"ReadBps", "WriteBps"} {
+		result.AddUnit("io.throttle."+key, UnitBytesPerSecond)
+	}
+	for _, key := range []string{"ReadIops", "WriteIops"} {
+		result.AddUnit("io.throttle."+key, UnitPerSecond)
+	}
+	for _, value := range result.Data {
+		if m, ok := value.(map[string]interface{}); ok {
+			for k, v := range m {
+				if v == "" {
+					delete(m, k)
+				}
+			}
+		}
+	}
+	result.Summary = cgroupSummary(result)
+	return result, nil
+}
+
+// paths parses /proc/self/cgroup into the path of every controller, the
+// unified (v2) hierarchy under the empty name.
+func (fs cgroupFS) paths() (map[string]string, error) {
+	f, err := os.Open(fs.procCgroup)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	paths := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[1] == "" {
+			paths[""] = parts[2]
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+			paths[controller+"@mount"] = parts[1]
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// dir finds the directory of a hierarchy. Inside a container the cgroup is
+// usually mounted as the root of the hierarchy, so the path of
+// /proc/self/cgroup does not exist below it.
+func (fs cgroupFS) dir(mount string, path string) string {
+	for _, dir := range []string{filepath.Join(fs.root, mount, path), filepath.Join(fs.root, mount)} {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+	}
+	return filepath.Join(fs.root, mount)
+}
+
+func (fs cgroupFS) probeV2(result *Result, path string) {
+	dir := fs.dir("", path)
+	result.Set("version", 2)
+	result.Set("path", path)
+
+	cpu := map[string]interface{}{}
+	if fields := strings.Fields(readCgroupFile(dir, "cpu.max")); len(fields) == 2 {
+		period, _ := strconv.ParseInt(fields[1], 10, 64)
+		cpu["PeriodMicros"] = period
+		setCPUQuota(cpu, fields[0], period)
+	}
+	stat := readKeyValues(dir, "cpu.stat")
+	if usage, ok := stat["usage_usec"]; ok {
+		cpu["UsageSeconds"] = float64(usage) / 1e6
+	}
+	if throttled, ok := stat["nr_throttled"]; ok {
+		cpu["NrThrottled"] = throttled
+		cpu["ThrottledSeconds"] = float64(stat["throttled_usec"]) / 1e6
+	}
+	result.Set("cpu", cpu)
+
+	result.Set("cpuset", map[string]interface{}{
+		"Cpus": readCgroupFile(dir, "cpuset.cpus.effective"),
+		"Mems": readCgroupFile(dir, "cpuset.mems.effective"),
+	})
+
+	memory := map[string]interface{}{
+		"Limit":     cgroupValue(readCgroupFile(dir, "memory.max")),
+		"Usage":     cgroupValue(readCgroupFile(dir, "memory.current")),
+		"SwapLimit": cgroupValue(readCgroupFile(dir, "memory.swap.max")),
+	}
+	if peak := readCgroupFile(dir, "memory.peak"); peak != "" {
+		memory["MaxUsage"] = cgroupValue(peak)
+	}
+	events := readKeyValues(dir, "memory.events")
+	memory["OOMKills"] = events["oom_kill"]
+	memory["OOMEvents"] = events["oom"]
+	setUsedPercent(memory)
+	result.Set("memory", memory)
+
+	result.Set("pids", map[string]interface{}{
+		"Limit":   cgroupValue(readCgroupFile(dir, "pids.max")),
+		"Current": cgroupValue(readCgroupFile(dir, "pids.current")),
+	})
+
+	var throttle []interface{}
+	for _, line := range strings.Split(readCgroupFile(dir, "io.max"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		device := map[string]interface{}{"Device": fields[0]}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "rbps":
+				device["ReadBps"] = cgroupValue(kv[1])
+			case "wbps":
+				device["WriteBps"] = cgroupValue(kv[1])
+			case "riops":
+				device["ReadIops"] = cgroupValue(kv[1])
+			case "wiops":
+				device["WriteIops"] = cgroupValue(kv[1])
+			}
+		}
+		throttle = append(throttle, device)
+	}
+	result.Set("io", map[string]interface{}{"throttle": throttleList(throttle)})
+}
+
+func (fs cgroupFS) probeV1(result *Result, paths map[string]string) {
+	dir := func(controller string) string {
+		if _, err := os.Stat(filepath.Join(fs.root, controller)); err == nil {
+			return fs.dir(controller, paths[controller])
+		}
+		return fs.dir(paths[controller+"@mount"], paths[controller])
+	}
+	result.Set("version", 1)
+	cgroupPaths := map[string]interface{}{}
+	for controller, path := range paths {
+		if controller != "" && !strings.HasSuffix(controller, "@mount") {
+			cgroupPaths[controller] = path
+		}
+	}
+	result.Set("path", cgroupPaths)
+
+	cpuDir := dir("cpu")
+	cpu := map[string]interface{}{}
+	if period, err := strconv.ParseInt(readCgroupFile(cpuDir, "cpu.cfs_period_us"), 10, 64); err == nil {
+		cpu["PeriodMicros"] = period
+		setCPUQuota(cpu, readCgroupFile(cpuDir, "cpu.cfs_quota_us"), period)
+	}
+	stat := readKeyValues(cpuDir, "cpu.stat")
+	if throttled, ok := stat["nr_throttled"]; ok {
+		cpu["NrThrottled"] = throttled
+		cpu["ThrottledSeconds"] = float64(stat["throttled_time"]) / 1e9
+	}
+	cpuacctDir := dir("cpuacct")
+	if usage, err := strconv.ParseUint(readCgroupFile(cpuacctDir, "cpuacct.usage"), 10, 64); err == nil {
+		cpu["UsageSeconds"] = float64(usage) / 1e9
+	}
+	if times, err := docker.CgroupCPU("", cpuacctDir); err == nil {
+		cpu["UserSeconds"] = times.User / userHZ
+		cpu["SystemSeconds"] = times.System / userHZ
+		result.AddUnit("cpu.UserSeconds", UnitSeconds)
+		result.AddUnit("cpu.SystemSeconds", UnitSeconds)
+	}
+	result.Set("cpu", cpu)
+
+	cpusetDir := dir("cpuset")
+	result.Set("cpuset", map[string]interface{}{
+		"Cpus": readCgroupFile(cpusetDir, "cpuset.cpus"),
+		"Mems": readCgroupFile(cpusetDir, "cpuset.mems"),
+	})
+
+	memoryDir := dir("memory")
+	memory := map[string]interface{}{
+		"Limit":     cgroupV1Bytes(readCgroupFile(memoryDir, "memory.limit_in_bytes")),
+		"Usage":     cgroupValue(readCgroupFile(memoryDir, "memory.usage_in_bytes")),
+		"MaxUsage":  cgroupValue(readCgroupFile(memoryDir, "memory.max_usage_in_bytes")),
+		"SwapLimit": cgroupV1Bytes(readCgroupFile(memoryDir, "memory.memsw.limit_in_bytes")),
+		"FailCnt":   cgroupValue(readCgroupFile(memoryDir, "memory.failcnt")),
+	}
+	oom := readKeyValues(memoryDir, "memory.oom_control")
+	memory["OOMKills"] = oom["oom_kill"]
+	memory["UnderOOM"] = oom["under_oom"] == 1
+	if stat, err := docker.CgroupMem("", memoryDir); err == nil {
+		memory["Cache"] = stat.Cache
+		memory["RSS"] = stat.RSS
+		memory["MappedFile"] = stat.MappedFile
+		memory["PgMajFault"] = stat.Pgmajfault
+	}
+	setUsedPercent(memory)
+	result.Set("memory", memory)
+
+	pidsDir := dir("pids")
+	result.Set("pids", map[string]interface{}{
+		"Limit":   cgroupValue(readCgroupFile(pidsDir, "pids.max")),
+		"Current": cgroupValue(readCgroupFile(pidsDir, "pids.current")),
+	})
+
+	blkioDir := dir("blkio")
+	devices := map[string]map[string]interface{}{}
+	var throttle []interface{}
+	for file, key := range map[string]string{
+		"blkio.throttle.read_bps_device":   "ReadBps",
+		"blkio.throttle.write_bps_device":  "WriteBps",
+		"blkio.throttle.read_iops_device":  "ReadIops",
+		"blkio.throttle.write_iops_device": "WriteIops",
+	} {
+		for _, line := range strings.Split(readCgroupFile(blkioDir, file), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+			device, ok := devices[fields[0]]
+			if !ok {
+				device = map[string]interface{}{"Device": fields[0]}
+				devices[fields[0]] = device
+				throttle = append(throttle, device)
+			}
+			device[key] = cgroupValue(fields[1])
+		}
+	}
+	result.Set("io", map[string]interface{}{"throttle": throttleList(throttle)})
+}
+
+// setCPUQuota records a CFS quota, "max" or -1 meaning unlimited, and the
+// number of CPUs it amounts to.
+func setCPUQuota(cpu map[string]interface{}, quota string, period int64) {
+	q, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || q < 0 || period <= 0 {
+		cpu["QuotaMicros"] = cgroupUnlimited
+		cpu["Limit"] = cgroupUnlimited
+		return
+	}
+	cpu["QuotaMicros"] = q
+	cpu["Limit"] = float64(q) / float64(period)
+}
+
+func setUsedPercent(memory map[string]interface{}) {
+	limit, ok1 := memory["Limit"].(uint64)
+	usage, ok2 := memory["Usage"].(uint64)
+	if ok1 && ok2 && limit > 0 {
+		memory["UsedPercent"] = 100 * float64(usage) / float64(limit)
+	}
+}
+
+func throttleList(throttle []interface{}) []interface{} {
+	if throttle == nil {
+		return []interface{}{}
+	}
+	return throttle
+}
+
+// cgroupValue parses a single value file, keeping "max" and anything else
+// which is not a number as a string. Missing files give "", which is dropped
+// from the result.
+func cgroupValue(value string) interface{} {
+	if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return n
+	}
+	return value
+}
+
+// cgroupV1Bytes is cgroupValue which reports the page aligned maximum int64
+// cgroup v1 uses for no limit as "max".
+func cgroupV1Bytes(value string) interface{} {
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return value
+	}
+	if n >= 1<<62 {
+		return cgroupUnlimited
+	}
+	return n
+}
+
+func readCgroupFile(dir string, name string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// readKeyValues parses flat keyed files such as cpu.stat and memory.events.
+func readKeyValues(dir string, name string) map[string]uint64 {
+	values := map[string]uint64{}
+	for _, line := range strings.Split(readCgroupFile(dir, name), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = n
+		}
+	}
+	return values
+}
+
+func cgroupSummary(result *Result) string {
+	cpu, _ := result.Data["cpu"].(map[string]interface{})
+	memory, _ := result.Data["memory"].(map[string]interface{})
+	pids, _ := result.Data["pids"].(map[string]interface{})
+	summary := fmt.Sprintf("Version: %v, CPU limit: %v, Memory usage: %v, limit: %v, OOM kills: %v",
+		result.Data["version"], cpu["Limit"], memory["Usage"], memory["Limit"], memory["OOMKills"])
+	if current, ok := pids["Current"]; ok {
+		summary += fmt.Sprintf(", Pids: %v/%v", current, pids["Limit"])
+	}
+	return summary
+}
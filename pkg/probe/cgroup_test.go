@@ -0,0 +1,92 @@
+package probe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestCgroupV2(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	writeFiles(t, root, map[string]string{
+		"proc/self/cgroup":          "0::/kubepods/pod1/abc\n",
+		"sys/cgroup.controllers":    "cpu memory pids io\n",
+		"sys/cpu.max":               "150000 100000\n",
+		"sys/cpu.stat":              "usage_usec 2500000\nnr_throttled 3\nthrottled_usec 500000\n",
+		"sys/cpuset.cpus.effective": "0-1\n",
+		"sys/memory.max":            "1073741824\n",
+		"sys/memory.current":        "268435456\n",
+		"sys/memory.swap.max":       "max\n",
+		"sys/memory.events":         "low 0\nhigh 0\nmax 4\noom 2\noom_kill 1\n",
+		"sys/pids.max":              "max\n",
+		"sys/pids.current":          "12\n",
+		"sys/io.max":                "8:0 rbps=1048576 wbps=max riops=max wiops=100\n",
+	})
+	fs := cgroupFS{procCgroup: filepath.Join(root, "proc/self/cgroup"), root: filepath.Join(root, "sys")}
+	r, err := fs.probe()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.Data["version"])
+	cpu := r.Data["cpu"].(map[string]interface{})
+	assert.Equal(t, 1.5, cpu["Limit"])
+	assert.Equal(t, 2.5, cpu["UsageSeconds"])
+	assert.Equal(t, uint64(3), cpu["NrThrottled"])
+	memory := r.Data["memory"].(map[string]interface{})
+	assert.Equal(t, uint64(1073741824), memory["Limit"])
+	assert.Equal(t, 25.0, memory["UsedPercent"])
+	assert.Equal(t, uint64(1), memory["OOMKills"])
+	assert.Equal(t, "max", memory["SwapLimit"])
+	pids := r.Data["pids"].(map[string]interface{})
+	assert.Equal(t, "max", pids["Limit"])
+	assert.Equal(t, uint64(12), pids["Current"])
+	throttle := r.Data["io"].(map[string]interface{})["throttle"].([]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"Device": "8:0", "ReadBps": uint64(1048576), "WriteBps": "max", "ReadIops": "max", "WriteIops": uint64(100),
+	}, throttle[0])
+	assert.Equal(t, UnitBytes, r.Unit("memory.Limit"))
+}
+
+func TestCgroupV1(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	writeFiles(t, root, map[string]string{
+		"proc/self/cgroup":                         "4:memory:/docker/abc\n3:cpu,cpuacct:/docker/abc\n2:pids:/docker/abc\n1:blkio:/docker/abc\n",
+		"sys/cpu,cpuacct/cpu.cfs_quota_us":         "-1\n",
+		"sys/cpu,cpuacct/cpu.cfs_period_us":        "100000\n",
+		"sys/cpu,cpuacct/cpuacct.usage":            "3000000000\n",
+		"sys/memory/memory.limit_in_bytes":         "9223372036854771712\n",
+		"sys/memory/memory.usage_in_bytes":         "1048576\n",
+		"sys/memory/memory.oom_control":            "oom_kill_disable 0\nunder_oom 0\noom_kill 2\n",
+		"sys/pids/pids.max":                        "100\n",
+		"sys/pids/pids.current":                    "7\n",
+		"sys/blkio/blkio.throttle.read_bps_device": "8:0 2097152\n",
+	})
+	fs := cgroupFS{procCgroup: filepath.Join(root, "proc/self/cgroup"), root: filepath.Join(root, "sys")}
+	r, err := fs.probe()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, r.Data["version"])
+	cpu := r.Data["cpu"].(map[string]interface{})
+	assert.Equal(t, "max", cpu["Limit"])
+	assert.Equal(t, 3.0, cpu["UsageSeconds"])
+	memory := r.Data["memory"].(map[string]interface{})
+	assert.Equal(t, "max", memory["Limit"])
+	assert.Equal(t, uint64(1048576), memory["Usage"])
+	assert.Equal(t, uint64(2), memory["OOMKills"])
+	pids := r.Data["pids"].(map[string]interface{})
+	assert.Equal(t, uint64(100), pids["Limit"])
+	throttle := r.Data["io"].(map[string]interface{})["throttle"].([]interface{})
+	assert.Equal(t, map[string]interface{}{"Device": "8:0", "ReadBps": uint64(2097152)}, throttle[0])
+}
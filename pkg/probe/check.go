@@ -0,0 +1,230 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checks dial out from go-probe's network namespace. A failing target is not
+// a failing probe, so they report Success and Error in their data along with
+// the timings measured until the failure.
+
+// CheckTCPFunc resolves and connects to the target parameter (host:port).
+func CheckTCPFunc(ctx context.Context) (*Result, error) {
+	result := NewResult("check-tcp")
+	target := Params(ctx).Get("target")
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, paramErrorf("Invalid target [%s], expect host:port", target)
+	}
+	result.Set("target", target)
+
+	start := time.Now()
+	ips, dnsTime, err := lookupHost(ctx, host)
+	if dnsTime > 0 {
+		result.Set("DNSTime", dnsTime.String())
+	}
+	if err != nil {
+		return checkFailed(result, start, err), nil
+	}
+	result.Set("Addresses", ips)
+
+	var conn net.Conn
+	var dialer net.Dialer
+	connectStart := time.Now()
+	for _, ip := range ips {
+		conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, port))
+		if err == nil {
+			break
+		}
+	}
+	result.Set("ConnectTime", time.Since(connectStart).String())
+	if err != nil {
+		return checkFailed(result, start, err), nil
+	}
+	defer conn.Close()
+	result.Set("LocalAddr", conn.LocalAddr().String())
+	result.Set("RemoteAddr", conn.RemoteAddr().String())
+	return checkSucceeded(result, start, fmt.Sprintf("Connected to %s", conn.RemoteAddr())), nil
+}
+
+// defaultMaxBody is how many bytes of the response body check-http reads
+// unless max_body says otherwise.
+const defaultMaxBody = 1 << 20
+
+// CheckHTTPFunc requests the url parameter, with the method parameter
+// (default GET), and reports the time spent in every phase. Redirects are
+// not followed and insecure=true skips certificate verification. At most
+// max_body bytes of the body are read, the rest is left and reported as
+// Truncated.
+func CheckHTTPFunc(ctx context.Context) (*Result, error) {
+	result := NewResult("check-http")
+	params := Params(ctx)
+	target := params.Get("url")
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, paramErrorf("Invalid url [%s]", target)
+	}
+	method := strings.ToUpper(params.Get("method"))
+	if method == "" {
+		method = http.MethodGet
+	}
+	maxBody := int64(defaultMaxBody)
+	if value := params.Get("max_body"); value != "" {
+		maxBody, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || maxBody <= 0 {
+			return nil, paramErrorf("Invalid max_body [%s]", value)
+		}
+	}
+	result.Set("url", target)
+	result.Set("method", method)
+
+	// Trace hooks may run on the transport's dialing goroutines, and the
+	// dialer races the IPv4 and IPv6 addresses, so connects are timed per
+	// address.
+	var lock sync.Mutex
+	set := func(key string, value interface{}) {
+		lock.Lock()
+		result.Set(key, value)
+		lock.Unlock()
+	}
+	mark := func(t *time.Time) {
+		lock.Lock()
+		*t = time.Now()
+		lock.Unlock()
+	}
+	since := func(t *time.Time) string {
+		lock.Lock()
+		defer lock.Unlock()
+		return time.Since(*t).String()
+	}
+	var dnsStart, tlsStart, start time.Time
+	connectStart := map[string]time.Time{}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { mark(&dnsStart) },
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			set("DNSTime", since(&dnsStart))
+			addrs := make([]string, 0, len(info.Addrs))
+			for _, addr := range info.Addrs {
+				addrs = append(addrs, addr.String())
+			}
+			set("Addresses", addrs)
+		},
+		ConnectStart: func(_, addr string) {
+			lock.Lock()
+			connectStart[addr] = time.Now()
+			lock.Unlock()
+		},
+		ConnectDone: func(_, addr string, err error) {
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			result.Set("ConnectTime", time.Since(connectStart[addr]).String())
+			result.Set("RemoteAddr", addr)
+			lock.Unlock()
+		},
+		TLSHandshakeStart: func() { mark(&tlsStart) },
+		TLSHandshakeDone: func(state tls.ConnectionState, _ error) {
+			set("TLSHandshakeTime", since(&tlsStart))
+			if state.HandshakeComplete {
+				set("TLSVersion", tlsVersionName(state.Version))
+			}
+		},
+		GotFirstResponseByte: func() {
+			set("TimeToFirstByte", time.Since(start).String())
+		},
+	}
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			DisableKeepAlives: true,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: params.Get("insecure") == "true"},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	start = time.Now()
+	resp, err := client.Do(req)
+	lock.Lock()
+	defer lock.Unlock()
+	if err != nil {
+		return checkFailed(result, start, err), nil
+	}
+	defer resp.Body.Close()
+	n, _ := io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxBody+1))
+	truncated := n > maxBody
+	if truncated {
+		n = maxBody
+	}
+	result.Set("StatusCode", resp.StatusCode)
+	result.Set("Proto", resp.Proto)
+	result.SetUnit("ContentLength", n, UnitBytes)
+	result.Set("Truncated", truncated)
+	if location := resp.Header.Get("Location"); location != "" {
+		result.Set("Location", location)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		result.Set("ContentType", contentType)
+	}
+	return checkSucceeded(result, start, fmt.Sprintf("%s %s: %s", method, target, resp.Status)), nil
+}
+
+// lookupHost resolves host, an IP address is returned as is without a DNS
+// time.
+func lookupHost(ctx context.Context, host string) ([]string, time.Duration, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, 0, nil
+	}
+	start := time.Now()
+	ips, err := net.DefaultResolver.LookupHost(ctx, host)
+	return ips, time.Since(start), err
+}
+
+func checkSucceeded(result *Result, start time.Time, summary string) *Result {
+	result.Set("Success", true)
+	result.Set("TotalTime", time.Since(start).String())
+	result.Summary = summary
+	return result
+}
+
+func checkFailed(result *Result, start time.Time, err error) *Result {
+	result.Set("Success", false)
+	result.Set("Error", err.Error())
+	result.Set("TotalTime", time.Since(start).String())
+	result.Summary = fmt.Sprintf("Failed: %s", err.Error())
+	return result
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionSSL30:
+		return "SSL 3.0"
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
@@ -0,0 +1,114 @@
+package probe
+
+import (
+	"context"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckTCPFunc(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	target := l.Addr().String()
+
+	ctx := WithParams(context.Background(), url.Values{"target": {target}})
+	r, err := CheckTCPFunc(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, true, r.Data["Success"])
+	assert.Equal(t, target, r.Data["RemoteAddr"])
+
+	l.Close()
+	r, err = CheckTCPFunc(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, false, r.Data["Success"])
+	assert.NotEmpty(t, r.Data["Error"])
+
+	_, err = CheckTCPFunc(WithParams(context.Background(), url.Values{"target": {"nohost"}}))
+	assert.Error(t, err)
+}
+
+func TestCheckHTTPFunc(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer server.Close()
+
+	ctx := WithParams(context.Background(), url.Values{"url": {server.URL}})
+	r, err := CheckHTTPFunc(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, true, r.Data["Success"])
+	assert.Equal(t, http.StatusFound, r.Data["StatusCode"])
+	assert.Equal(t, "/elsewhere", r.Data["Location"])
+	assert.NotEmpty(t, r.Data["ConnectTime"])
+	assert.NotEmpty(t, r.Data["TimeToFirstByte"])
+
+	// localhost may resolve to ::1 and 127.0.0.1, which the dialer races;
+	// only the connect which succeeded is reported.
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	ctx = WithParams(context.Background(), url.Values{"url": {"http://localhost:" + port}})
+	r, err = CheckHTTPFunc(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, server.Listener.Addr().String(), r.Data["RemoteAddr"])
+	assert.NotEmpty(t, r.Data["ConnectTime"])
+}
+
+func TestCheckHTTPFuncMaxBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 100))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		maxBody   string
+		length    int64
+		truncated bool
+	}{
+		{"", 100, false},
+		{"100", 100, false},
+		{"10", 10, true},
+	}
+	for _, test := range tests {
+		ctx := WithParams(context.Background(), url.Values{"url": {server.URL}, "max_body": {test.maxBody}})
+		r, err := CheckHTTPFunc(ctx)
+		if assert.NoError(t, err, test.maxBody) {
+			assert.Equal(t, test.length, r.Data["ContentLength"], test.maxBody)
+			assert.Equal(t, test.truncated, r.Data["Truncated"], test.maxBody)
+		}
+	}
+	for _, maxBody := range []string{"0", "-1", "1MB"} {
+		_, err := CheckHTTPFunc(WithParams(context.Background(), url.Values{"url": {server.URL}, "max_body": {maxBody}}))
+		assert.IsType(t, &ParamError{}, err, maxBody)
+	}
+}
+
+func TestCheckTLSFunc(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	target := server.Listener.Addr().String()
+
+	ctx := WithParams(context.Background(), url.Values{"target": {target}, "sni": {"example.com"}})
+	r, err := CheckTLSFunc(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, true, r.Data["Success"])
+	// The test certificate is not signed by a system CA.
+	assert.Equal(t, false, r.Data["Verified"])
+	chain := r.Data["Chain"].([]interface{})
+	assert.Contains(t, chain[0].(map[string]interface{})["DNSNames"], "example.com")
+
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	ctx = WithParams(context.Background(), url.Values{"target": {target}, "sni": {"example.com"}, "ca": {ca}})
+	r, err = CheckTLSFunc(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, true, r.Data["Verified"])
+
+	// A path is not read.
+	ctx = WithParams(context.Background(), url.Values{"target": {target}, "ca": {"/etc/passwd"}})
+	_, err = CheckTLSFunc(ctx)
+	assert.Error(t, err)
+}
@@ -0,0 +1,179 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/shirou/gopsutil/net"
+	"github.com/shirou/gopsutil/process"
+)
+
+// ConnectionsFunc lists sockets, filtered by the parameters kind (all, tcp,
+// tcp4, tcp6, udp, udp4, udp6, unix, inet, inet4, inet6), state (one or more
+// comma separated TCP states such as LISTEN or TIME_WAIT) and pid.
+func ConnectionsFunc(ctx context.Context) (*Result, error) {
+	params := Params(ctx)
+	conns, err := connections(params.Get("kind"), params.Get("pid"))
+	if err != nil {
+		return nil, err
+	}
+	return connectionsResult(conns, params.Get("state")), nil
+}
+
+// connectionsResult lists conns in one of the comma separated states, all
+// when state is empty, and counts them by state.
+func connectionsResult(conns []net.ConnectionStat, state string) *Result {
+	result := NewResult("connections")
+	states := map[string]bool{}
+	for _, state := range strings.Split(state, ",") {
+		if state = strings.TrimSpace(state); state != "" {
+			states[strings.ToUpper(state)] = true
+		}
+	}
+	counts := map[string]interface{}{}
+	list := make([]interface{}, 0, len(conns))
+	for _, conn := range conns {
+		if len(states) > 0 && !states[conn.Status] {
+			continue
+		}
+		list = append(list, connectionData(conn))
+		if conn.Status != "NONE" && conn.Status != "" {
+			count, _ := counts[conn.Status].(int)
+			counts[conn.Status] = count + 1
+		}
+	}
+	result.Set("count", len(list))
+	result.Set("states", counts)
+	result.Set("connections", list)
+	summary := []string{fmt.Sprintf("Connections: %d", len(list))}
+	for _, state := range SortedKeys(counts) {
+		summary = append(summary, fmt.Sprintf("%s: %d", state, counts[state]))
+	}
+	result.Summary = strings.Join(summary, ", ")
+	return result
+}
+
+// ListeningPortsFunc lists the TCP ports in LISTEN state and the bound UDP
+// ports, with the process owning them.
+func ListeningPortsFunc(_ context.Context) (*Result, error) {
+	result := NewResult("listening-ports")
+	conns, err := net.Connections("inet")
+	if err != nil {
+		return nil, err
+	}
+	listening := listeningConns(conns)
+	names := map[int32]string{}
+	list := make([]interface{}, 0, len(listening))
+	var ports []string
+	for _, conn := range listening {
+		port := map[string]interface{}{
+			"Protocol": protocol(conn),
+			"Address":  conn.Laddr.IP,
+			"Port":     conn.Laddr.Port,
+			"Pid":      conn.Pid,
+		}
+		if conn.Pid > 0 {
+			name, ok := names[conn.Pid]
+			if !ok {
+				if p, err := process.NewProcess(conn.Pid); err == nil {
+					name, _ = p.Name()
+				}
+				names[conn.Pid] = name
+			}
+			port["Process"] = name
+		}
+		list = append(list, port)
+		portName := fmt.Sprintf("%d/%s", conn.Laddr.Port, strings.TrimRight(protocol(conn), "6"))
+		if len(ports) == 0 || ports[len(ports)-1] != portName {
+			ports = append(ports, portName)
+		}
+	}
+	result.Set("count", len(list))
+	result.Set("ports", list)
+	result.Summary = "Listening: " + strings.Join(ports, ", ")
+	return result, nil
+}
+
+// connectionKinds are the kinds gopsutil knows, it errors on any other.
+var connectionKinds = map[string]bool{
+	"all": true, "tcp": true, "tcp4": true, "tcp6": true, "udp": true, "udp4": true, "udp6": true,
+	"unix": true, "inet": true, "inet4": true, "inet6": true,
+}
+
+func connections(kind string, pid string) ([]net.ConnectionStat, error) {
+	if kind == "" {
+		kind = "all"
+	}
+	if !connectionKinds[kind] {
+		return nil, paramErrorf("Invalid kind [%s]", kind)
+	}
+	if pid == "" {
+		return net.Connections(kind)
+	}
+	id, err := strconv.ParseInt(pid, 10, 32)
+	if err != nil {
+		return nil, paramErrorf("Invalid pid [%s]", pid)
+	}
+	return net.ConnectionsPid(kind, int32(id))
+}
+
+// listeningConns returns the TCP sockets in LISTEN state and the unconnected
+// UDP sockets of conns, ordered by port then protocol.
+func listeningConns(conns []net.ConnectionStat) []net.ConnectionStat {
+	var listening []net.ConnectionStat
+	for _, conn := range conns {
+		if conn.Status == "LISTEN" || (conn.Type == syscall.SOCK_DGRAM && conn.Raddr.Port == 0) {
+			listening = append(listening, conn)
+		}
+	}
+	sort.Slice(listening, func(i, j int) bool {
+		if listening[i].Laddr.Port != listening[j].Laddr.Port {
+			return listening[i].Laddr.Port < listening[j].Laddr.Port
+		}
+		return protocol(listening[i]) < protocol(listening[j])
+	})
+	return listening
+}
+
+func connectionData(conn net.ConnectionStat) map[string]interface{} {
+	return map[string]interface{}{
+		"Fd":       conn.Fd,
+		"Protocol": protocol(conn),
+		"Laddr":    addrString(conn.Laddr),
+		"Raddr":    addrString(conn.Raddr),
+		"Status":   conn.Status,
+		"Pid":      conn.Pid,
+	}
+}
+
+// protocol names the socket family and type the way netstat does, e.g. tcp6.
+func protocol(conn net.ConnectionStat) string {
+	var name string
+	switch conn.Family {
+	case syscall.AF_UNIX:
+		return "unix"
+	case syscall.AF_INET6:
+		name = "6"
+	}
+	switch conn.Type {
+	case syscall.SOCK_STREAM:
+		return "tcp" + name
+	case syscall.SOCK_DGRAM:
+		return "udp" + name
+	}
+	return fmt.Sprintf("%d/%d", conn.Family, conn.Type)
+}
+
+func addrString(addr net.Addr) string {
+	if addr.IP == "" && addr.Port == 0 {
+		return ""
+	}
+	if strings.Contains(addr.IP, ":") {
+		return fmt.Sprintf("[%s]:%d", addr.IP, addr.Port)
+	}
+	return fmt.Sprintf("%s:%d", addr.IP, addr.Port)
+}
@@ -0,0 +1,111 @@
+package probe
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/shirou/gopsutil/net"
+	"github.com/stretchr/testify/assert"
+)
+
+var testConns = []net.ConnectionStat{
+	{Fd: 3, Family: syscall.AF_INET, Type: syscall.SOCK_STREAM, Laddr: net.Addr{IP: "0.0.0.0", Port: 8080}, Status: "LISTEN", Pid: 10},
+	{Fd: 4, Family: syscall.AF_INET6, Type: syscall.SOCK_STREAM, Laddr: net.Addr{IP: "::", Port: 22}, Status: "LISTEN", Pid: 11},
+	{Fd: 5, Family: syscall.AF_INET, Type: syscall.SOCK_STREAM, Laddr: net.Addr{IP: "10.0.0.1", Port: 8080},
+		Raddr: net.Addr{IP: "10.0.0.2", Port: 51000}, Status: "ESTABLISHED", Pid: 10},
+	{Fd: 6, Family: syscall.AF_INET, Type: syscall.SOCK_STREAM, Laddr: net.Addr{IP: "10.0.0.1", Port: 8080},
+		Raddr: net.Addr{IP: "10.0.0.3", Port: 51001}, Status: "TIME_WAIT"},
+	{Fd: 7, Family: syscall.AF_INET, Type: syscall.SOCK_DGRAM, Laddr: net.Addr{IP: "0.0.0.0", Port: 53}, Status: "NONE", Pid: 12},
+	{Fd: 8, Family: syscall.AF_INET6, Type: syscall.SOCK_DGRAM, Laddr: net.Addr{IP: "::1", Port: 53},
+		Raddr: net.Addr{IP: "::1", Port: 5353}, Status: "NONE", Pid: 12},
+	{Fd: 9, Family: syscall.AF_UNIX, Type: syscall.SOCK_STREAM, Laddr: net.Addr{IP: "/run/app.sock"}, Status: "NONE", Pid: 13},
+}
+
+func TestProtocol(t *testing.T) {
+	tests := []struct {
+		family   uint32
+		sockType uint32
+		protocol string
+	}{
+		{syscall.AF_INET, syscall.SOCK_STREAM, "tcp"},
+		{syscall.AF_INET6, syscall.SOCK_STREAM, "tcp6"},
+		{syscall.AF_INET, syscall.SOCK_DGRAM, "udp"},
+		{syscall.AF_INET6, syscall.SOCK_DGRAM, "udp6"},
+		{syscall.AF_UNIX, syscall.SOCK_DGRAM, "unix"},
+		{syscall.AF_INET, syscall.SOCK_RAW, "2/3"},
+	}
+	for _, test := range tests {
+		conn := net.ConnectionStat{Family: test.family, Type: test.sockType}
+		assert.Equal(t, test.protocol, protocol(conn), "%d/%d", test.family, test.sockType)
+	}
+}
+
+func TestAddrString(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		s    string
+	}{
+		{net.Addr{}, ""},
+		{net.Addr{IP: "127.0.0.1", Port: 80}, "127.0.0.1:80"},
+		{net.Addr{IP: "0.0.0.0"}, "0.0.0.0:0"},
+		{net.Addr{IP: "::1", Port: 443}, "[::1]:443"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.s, addrString(test.addr), "%v", test.addr)
+	}
+}
+
+func TestConnectionsResult(t *testing.T) {
+	tests := []struct {
+		state   string
+		fds     []uint32
+		states  map[string]interface{}
+		summary string
+	}{
+		{"", []uint32{3, 4, 5, 6, 7, 8, 9}, map[string]interface{}{"LISTEN": 2, "ESTABLISHED": 1, "TIME_WAIT": 1},
+			"Connections: 7, ESTABLISHED: 1, LISTEN: 2, TIME_WAIT: 1"},
+		{"listen", []uint32{3, 4}, map[string]interface{}{"LISTEN": 2}, "Connections: 2, LISTEN: 2"},
+		{"ESTABLISHED, time_wait", []uint32{5, 6}, map[string]interface{}{"ESTABLISHED": 1, "TIME_WAIT": 1},
+			"Connections: 2, ESTABLISHED: 1, TIME_WAIT: 1"},
+		{"CLOSE_WAIT", []uint32{}, map[string]interface{}{}, "Connections: 0"},
+	}
+	for _, test := range tests {
+		r := connectionsResult(testConns, test.state)
+		list := r.Data["connections"].([]interface{})
+		fds := make([]uint32, 0, len(list))
+		for _, conn := range list {
+			fds = append(fds, conn.(map[string]interface{})["Fd"].(uint32))
+		}
+		assert.Equal(t, test.fds, fds, test.state)
+		assert.Equal(t, len(test.fds), r.Data["count"], test.state)
+		assert.Equal(t, test.states, r.Data["states"], test.state)
+		assert.Equal(t, test.summary, r.Summary, test.state)
+	}
+
+	r := connectionsResult(testConns[2:3], "")
+	assert.Equal(t, map[string]interface{}{
+		"Fd":       uint32(5),
+		"Protocol": "tcp",
+		"Laddr":    "10.0.0.1:8080",
+		"Raddr":    "10.0.0.2:51000",
+		"Status":   "ESTABLISHED",
+		"Pid":      int32(10),
+	}, r.Data["connections"].([]interface{})[0])
+}
+
+func TestConnectionsParams(t *testing.T) {
+	for _, params := range [][2]string{{"bogus", ""}, {"TCP", ""}, {"tcp", "abc"}} {
+		_, err := connections(params[0], params[1])
+		assert.IsType(t, &ParamError{}, err, "%v", params)
+	}
+}
+
+func TestListeningConns(t *testing.T) {
+	var fds []uint32
+	for _, conn := range listeningConns(testConns) {
+		fds = append(fds, conn.Fd)
+	}
+	// Port 22 tcp6, port 53 udp, port 8080 tcp; the connected UDP socket,
+	// the established TCP ones and the unix socket are left out.
+	assert.Equal(t, []uint32{4, 7, 3}, fds)
+}
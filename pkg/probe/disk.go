@@ -0,0 +1,101 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/shirou/gopsutil/disk"
+)
+
+// pseudoFstypes are kernel filesystems which are not storage, they are left
+// out of disk-info and disk-usage. tmpfs and overlay are kept since container
+// volumes and root filesystems use them.
+var pseudoFstypes = map[string]bool{
+	"autofs":      true,
+	"binfmt_misc": true,
+	"bpf":         true,
+	"cgroup":      true,
+	"cgroup2":     true,
+	"configfs":    true,
+	"debugfs":     true,
+	"devpts":      true,
+	"fusectl":     true,
+	"hugetlbfs":   true,
+	"mqueue":      true,
+	"nsfs":        true,
+	"proc":        true,
+	"pstore":      true,
+	"rpc_pipefs":  true,
+	"securityfs":  true,
+	"selinuxfs":   true,
+	"sysfs":       true,
+	"tracefs":     true,
+}
+
+func partitions() ([]disk.PartitionStat, error) {
+	all, err := disk.Partitions(true)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]disk.PartitionStat, 0, len(all))
+	for _, part := range all {
+		if !pseudoFstypes[part.Fstype] {
+			parts = append(parts, part)
+		}
+	}
+	return parts, nil
+}
+
+func DiskInfoFunc(_ context.Context) (*Result, error) {
+	result := NewResult("disk-info")
+	parts, err := partitions()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]interface{}, 0, len(parts))
+	for _, part := range parts {
+		list = append(list, StructData(part))
+	}
+	result.Summary = fmt.Sprintf("Partitions: %d", len(parts))
+	result.Set("count", len(parts))
+	result.Set("partitions", list)
+	return result, nil
+}
+
+func DiskUsageFunc(ctx context.Context) (*Result, error) {
+	result := NewResult("disk-usage")
+	parts, err := partitions()
+	if err != nil {
+		return nil, err
+	}
+	var fullest *disk.UsageStat
+	mounts := make([]interface{}, 0, len(parts))
+	for _, part := range parts {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		usage, err := disk.Usage(part.Mountpoint)
+		if err != nil {
+			mounts = append(mounts, map[string]interface{}{"Path": part.Mountpoint, "Error": err.Error()})
+			continue
+		}
+		data := StructData(usage)
+		data["Device"] = part.Device
+		mounts = append(mounts, data)
+		if fullest == nil || usage.UsedPercent > fullest.UsedPercent {
+			fullest = usage
+		}
+	}
+	result.Set("mounts", mounts)
+	for _, key := range []string{"Total", "Free", "Used"} {
+		result.AddUnit("mounts."+key, UnitBytes)
+	}
+	result.AddUnit("mounts.UsedPercent", UnitPercent)
+	result.AddUnit("mounts.InodesUsedPercent", UnitPercent)
+	if fullest != nil {
+		result.Summary = fmt.Sprintf("Mounts: %d, Fullest: %s Total: %v, Free:%v, UsedPercent:%f%%", len(mounts), fullest.Path, fullest.Total, fullest.Free, fullest.UsedPercent)
+	} else {
+		result.Summary = fmt.Sprintf("Mounts: %d", len(mounts))
+	}
+	return result, nil
+}
@@ -0,0 +1,301 @@
+package probe
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	resolvConfPath = "/etc/resolv.conf"
+	hostsPath      = "/etc/hosts"
+)
+
+// resolvConf is the part of resolv.conf which decides how a name is looked up.
+type resolvConf struct {
+	Nameservers []string
+	Search      []string
+	Options     []string
+	Ndots       int
+}
+
+func readResolvConf(path string) (*resolvConf, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	conf := &resolvConf{Ndots: 1}
+	var domain []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			conf.Nameservers = append(conf.Nameservers, fields[1])
+		case "domain":
+			domain = fields[1:2]
+		case "search":
+			conf.Search = fields[1:]
+		case "options":
+			for _, option := range fields[1:] {
+				conf.Options = append(conf.Options, option)
+				if strings.HasPrefix(option, "ndots:") {
+					if n, err := strconv.Atoi(option[len("ndots:"):]); err == nil {
+						conf.Ndots = n
+					}
+				}
+			}
+		}
+	}
+	if conf.Search == nil {
+		conf.Search = domain
+	}
+	return conf, scanner.Err()
+}
+
+func readHosts(path string) ([]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hosts := []interface{}{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		hosts = append(hosts, map[string]interface{}{"IP": fields[0], "Names": fields[1:]})
+	}
+	return hosts, scanner.Err()
+}
+
+// DNSFunc reports the resolver configuration of /etc/resolv.conf and the
+// entries of /etc/hosts.
+func DNSFunc(_ context.Context) (*Result, error) {
+	result := NewResult("dns")
+	conf, err := readResolvConf(resolvConfPath)
+	if err != nil {
+		return nil, err
+	}
+	result.Set("nameservers", conf.Nameservers)
+	result.Set("search", conf.Search)
+	result.Set("options", conf.Options)
+	result.Set("ndots", conf.Ndots)
+	if hosts, err := readHosts(hostsPath); err == nil {
+		result.Set("hosts", hosts)
+	}
+	result.Summary = fmt.Sprintf("Nameservers: %s, Search: %s, Ndots: %d",
+		strings.Join(conf.Nameservers, " "), strings.Join(conf.Search, " "), conf.Ndots)
+	return result, nil
+}
+
+// hostsNames returns the names of the hosts file at path, lower case, keyed
+// the way the Go resolver matches them: a name with a dot is also matched
+// fully qualified, a single label never is.
+func hostsNames(path string) map[string]bool {
+	names := map[string]bool{}
+	hosts, _ := readHosts(path)
+	for _, host := range hosts {
+		for _, name := range host.(map[string]interface{})["Names"].([]string) {
+			name = strings.ToLower(name)
+			if strings.Contains(name, ".") && !strings.HasSuffix(name, ".") {
+				name += "."
+			}
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// hostsTypes are the record types the Go resolver answers from the hosts
+// file before asking any server.
+var hostsTypes = map[string]bool{"A": true, "AAAA": true, "CNAME": true}
+
+// DNSResolveFunc resolves the name parameter for one or more comma separated
+// record types (A, AAAA, CNAME, SRV, TXT, MX, default A) against the system
+// resolver, or the server parameter (host or host:port) when given. The search
+// domains and ndots of resolv.conf are applied the way the system resolver
+// does, reporting the time of every candidate name tried. Names in /etc/hosts
+// are answered from there for A, AAAA and CNAME even with a server, such an
+// answer is marked with the source hosts.
+func DNSResolveFunc(ctx context.Context) (*Result, error) {
+	params := Params(ctx)
+	name := params.Get("name")
+	if name == "" {
+		return nil, paramErrorf("Missing name parameter")
+	}
+	conf, err := readResolvConf(resolvConfPath)
+	if err != nil {
+		conf = &resolvConf{Ndots: 1}
+	}
+	types := strings.Split(strings.ToUpper(params.Get("type")), ",")
+	if params.Get("type") == "" {
+		types = []string{"A"}
+	}
+	result, err := resolve(ctx, conf, params.Get("server"), name, types)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func resolve(ctx context.Context, conf *resolvConf, server string, name string, types []string) (*Result, error) {
+	result := NewResult("dns-resolve")
+	resolver := &net.Resolver{PreferGo: true}
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resolver.Dial = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		}
+		result.Set("server", server)
+	} else {
+		result.Set("server", "system")
+	}
+	candidates := searchNames(conf, name)
+	hosts := hostsNames(hostsPath)
+	result.Set("name", name)
+	result.Set("candidates", candidates)
+	result.Set("ndots", conf.Ndots)
+
+	var summary []string
+	queries := make([]interface{}, 0, len(types))
+	for _, recordType := range types {
+		recordType = strings.TrimSpace(recordType)
+		lookup, ok := lookups[recordType]
+		if !ok {
+			return nil, paramErrorf("Unsupported record type [%s]", recordType)
+		}
+		query := map[string]interface{}{"Type": recordType}
+		attempts := make([]interface{}, 0, len(candidates))
+		var total time.Duration
+		for _, candidate := range candidates {
+			start := time.Now()
+			records, err := lookup(ctx, resolver, candidate)
+			elapsed := time.Since(start)
+			total += elapsed
+			attempt := map[string]interface{}{
+				"Name":     candidate,
+				"Duration": elapsed.String(),
+			}
+			if err != nil {
+				attempt["Error"] = err.Error()
+				attempts = append(attempts, attempt)
+				if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+					continue
+				}
+				break
+			}
+			attempt["Records"] = records
+			attempts = append(attempts, attempt)
+			query["Answer"] = candidate
+			query["Records"] = records
+			if hostsTypes[recordType] && hosts[strings.ToLower(candidate)] {
+				query["Source"] = "hosts"
+			}
+			break
+		}
+		query["Attempts"] = attempts
+		query["Duration"] = total.String()
+		queries = append(queries, query)
+		if records, ok := query["Records"].([]string); ok && query["Source"] == "hosts" {
+			summary = append(summary, fmt.Sprintf("%s: %s (hosts)", recordType, strings.Join(records, " ")))
+		} else if ok {
+			summary = append(summary, fmt.Sprintf("%s: %s", recordType, strings.Join(records, " ")))
+		} else {
+			summary = append(summary, fmt.Sprintf("%s: not found after %d attempts", recordType, len(attempts)))
+		}
+	}
+	result.Set("queries", queries)
+	result.Summary = strings.Join(summary, ", ")
+	return result, nil
+}
+
+// searchNames lists the fully qualified names the resolver tries for name:
+// a name with at least ndots dots is tried as is before the search domains,
+// any other after them, and a name ending in a dot only as is.
+func searchNames(conf *resolvConf, name string) []string {
+	if strings.HasSuffix(name, ".") {
+		return []string{name}
+	}
+	var names []string
+	for _, domain := range conf.Search {
+		names = append(names, name+"."+strings.TrimSuffix(domain, ".")+".")
+	}
+	if strings.Count(name, ".") >= conf.Ndots {
+		return append([]string{name + "."}, names...)
+	}
+	return append(names, name+".")
+}
+
+type lookupFunc func(ctx context.Context, resolver *net.Resolver, name string) ([]string, error)
+
+var lookups = map[string]lookupFunc{
+	"A": func(ctx context.Context, resolver *net.Resolver, name string) ([]string, error) {
+		return lookupIP(ctx, resolver, "ip4", name)
+	},
+	"AAAA": func(ctx context.Context, resolver *net.Resolver, name string) ([]string, error) {
+		return lookupIP(ctx, resolver, "ip6", name)
+	},
+	"CNAME": func(ctx context.Context, resolver *net.Resolver, name string) ([]string, error) {
+		cname, err := resolver.LookupCNAME(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return []string{cname}, nil
+	},
+	"SRV": func(ctx context.Context, resolver *net.Resolver, name string) ([]string, error) {
+		_, srvs, err := resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		records := make([]string, 0, len(srvs))
+		for _, srv := range srvs {
+			records = append(records, fmt.Sprintf("%d %d %d %s", srv.Priority, srv.Weight, srv.Port, srv.Target))
+		}
+		return records, nil
+	},
+	"TXT": func(ctx context.Context, resolver *net.Resolver, name string) ([]string, error) {
+		return resolver.LookupTXT(ctx, name)
+	},
+	"MX": func(ctx context.Context, resolver *net.Resolver, name string) ([]string, error) {
+		mxs, err := resolver.LookupMX(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		records := make([]string, 0, len(mxs))
+		for _, mx := range mxs {
+			records = append(records, fmt.Sprintf("%d %s", mx.Pref, mx.Host))
+		}
+		return records, nil
+	},
+}
+
+func lookupIP(ctx context.Context, resolver *net.Resolver, network string, name string) ([]string, error) {
+	ips, err := resolver.LookupIP(ctx, network, name)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		records = append(records, ip.String())
+	}
+	return records, nil
+}
@@ -0,0 +1,156 @@
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveDNS answers A queries for the names in records over UDP on a local
+// port and NXDOMAIN for anything else, until the returned conn is closed.
+func serveDNS(t *testing.T, records map[string]net.IP) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := dnsResponse(buf[:n], records); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return conn
+}
+
+func dnsResponse(query []byte, records map[string]net.IP) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	// Walk the labels of the single question.
+	var labels []string
+	i := 12
+	for i < len(query) && query[i] != 0 {
+		l := int(query[i])
+		labels = append(labels, string(query[i+1:i+1+l]))
+		i += l + 1
+	}
+	end := i + 5 // zero label, type and class
+	if end > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[i+1:])
+	name := strings.ToLower(strings.Join(labels, ".")) + "."
+
+	resp := make([]byte, 12, 512)
+	copy(resp, query[:2])
+	ip, ok := records[name]
+	flags := uint16(0x8180)
+	if !ok {
+		flags |= 3 // NXDOMAIN
+	}
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	resp = append(resp, query[12:end]...)
+	if ok && qtype == 1 {
+		binary.BigEndian.PutUint16(resp[6:], 1)
+		resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+		resp = append(resp, ip.To4()...)
+	}
+	return resp
+}
+
+func TestResolveSearchDomains(t *testing.T) {
+	conn := serveDNS(t, map[string]net.IP{
+		"web.default.cluster.local.": net.ParseIP("10.0.0.7"),
+		"example.com.":               net.ParseIP("93.184.216.34"),
+	})
+	defer conn.Close()
+	conf := &resolvConf{Search: []string{"svc.cluster.local", "default.cluster.local"}, Ndots: 5}
+
+	r, err := resolve(context.Background(), conf, conn.LocalAddr().String(), "web", []string{"A"})
+	assert.NoError(t, err)
+	query := r.Data["queries"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "web.default.cluster.local.", query["Answer"])
+	assert.Equal(t, []string{"10.0.0.7"}, query["Records"])
+	attempts := query["Attempts"].([]interface{})
+	assert.Len(t, attempts, 2)
+	assert.Equal(t, "web.svc.cluster.local.", attempts[0].(map[string]interface{})["Name"])
+	assert.NotEmpty(t, attempts[0].(map[string]interface{})["Error"])
+
+	// With ndots:5 a name with a single dot still goes through the search
+	// domains first.
+	r, err = resolve(context.Background(), conf, conn.LocalAddr().String(), "example.com", []string{"A"})
+	assert.NoError(t, err)
+	query = r.Data["queries"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "example.com.", query["Answer"])
+	assert.Len(t, query["Attempts"], 3)
+}
+
+func TestHostsNames(t *testing.T) {
+	f, err := ioutil.TempFile("", "hosts")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("127.0.0.1 localhost\n10.0.0.1 Registry.Example.com registry # local mirror\n")
+	f.Close()
+	assert.Equal(t, map[string]bool{"localhost": true, "registry.example.com.": true, "registry": true}, hostsNames(f.Name()))
+}
+
+func TestResolveHosts(t *testing.T) {
+	// The resolver only answers fully qualified names, the candidates, from
+	// the hosts file when they have a dot.
+	var name string
+	for key := range hostsNames(hostsPath) {
+		if strings.HasSuffix(key, ".") {
+			name = key
+			break
+		}
+	}
+	if name == "" {
+		t.Skip("no name with a dot in " + hostsPath)
+	}
+	conn := serveDNS(t, map[string]net.IP{"example.com.": net.ParseIP("93.184.216.34")})
+	defer conn.Close()
+	conf := &resolvConf{Ndots: 1}
+
+	// The server answers NXDOMAIN for name, the hosts file answers first all
+	// the same.
+	r, err := resolve(context.Background(), conf, conn.LocalAddr().String(), name, []string{"A", "AAAA"})
+	assert.NoError(t, err)
+	var fromHosts bool
+	for _, query := range r.Data["queries"].([]interface{}) {
+		fromHosts = fromHosts || query.(map[string]interface{})["Source"] == "hosts"
+	}
+	assert.True(t, fromHosts, name)
+	assert.Contains(t, r.Summary, "(hosts)")
+
+	r, err = resolve(context.Background(), conf, conn.LocalAddr().String(), "example.com.", []string{"A"})
+	assert.NoError(t, err)
+	query := r.Data["queries"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, []string{"93.184.216.34"}, query["Records"])
+	assert.Nil(t, query["Source"])
+}
+
+func TestReadResolvConf(t *testing.T) {
+	f, err := ioutil.TempFile("", "resolv.conf")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("# comment\nnameserver 10.96.0.10\nsearch default.svc.cluster.local svc.cluster.local\noptions ndots:5 timeout:2\n")
+	f.Close()
+
+	conf, err := readResolvConf(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.96.0.10"}, conf.Nameservers)
+	assert.Equal(t, []string{"default.svc.cluster.local", "svc.cluster.local"}, conf.Search)
+	assert.Equal(t, 5, conf.Ndots)
+	assert.Equal(t, []string{"ndots:5", "timeout:2"}, conf.Options)
+}
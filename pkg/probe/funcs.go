@@ -3,18 +3,17 @@ package probe
 import (
 	"context"
 	"fmt"
-	"github.com/fatih/structs"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/host"
 	"github.com/shirou/gopsutil/load"
+	"github.com/shirou/gopsutil/mem"
 	"net"
 	"os"
+	"strconv"
 	"strings"
-	"net/http"
-	"github.com/shirou/gopsutil/mem"
 )
 
-const  version  = "v0.2"
+const version = "v0.2"
 
 func init() {
 	single.Register("env", EnvFunc)
@@ -25,26 +24,88 @@ func init() {
 	single.Register("request-info", RequestInfoFunc)
 	single.Register("memory-info", MemoryInfoFunc)
 	single.Register("status", StatusFunc)
+	single.Register("disk-info", DiskInfoFunc)
+	single.Register("disk-usage", DiskUsageFunc)
+	single.Register("processes", ProcessesFunc)
+	single.RegisterOnDemand("process", ProcessFunc)
+	single.Register("connections", ConnectionsFunc)
+	single.Register("listening-ports", ListeningPortsFunc)
+	single.Register("network-io", NetworkIOFunc)
+	single.Register("cgroup", CgroupFunc)
+	single.Register("dns", DNSFunc)
+	single.RegisterOnDemand("dns-resolve", DNSResolveFunc)
+	single.RegisterOnDemand("check-tcp", CheckTCPFunc)
+	single.RegisterOnDemand("check-http", CheckHTTPFunc)
+	single.RegisterOnDemand("check-tls", CheckTLSFunc)
 }
 
 func StatusFunc(_ context.Context) (*Result, error) {
 	result := NewResult("status")
-	result.Data["status"] = "ok"
-	result.Data["version"] = version
+	result.Set("status", "ok")
+	result.Set("version", version)
 	return result, nil
 }
 
-func EnvFunc(_ context.Context) (*Result, error) {
+// EnvFunc reports the environment of go-probe, or of the process given by the
+// pid parameter, as a map of variables and as the raw environment in its
+// original order. Variables set more than once are listed under duplicates
+// with all their values.
+func EnvFunc(ctx context.Context) (*Result, error) {
 	result := NewResult("env")
-	for _, e := range os.Environ() {
-		pair := strings.Split(e, "=")
-		if len(pair) >= 2 {
-			result.Data[pair[0]] = pair[1]
+	params := Params(ctx)
+	environ := os.Environ()
+	source := "self"
+	if pid := params.Get("pid"); pid != "" {
+		id, err := strconv.ParseInt(pid, 10, 32)
+		if err != nil {
+			return nil, paramErrorf("Invalid pid [%s]", pid)
+		}
+		if environ, err = readEnviron(int32(id)); err != nil {
+			return nil, err
 		}
+		source = "pid " + pid
 	}
+	variables, duplicates := parseEnviron(environ)
+	result.Set("variables", variables)
+	if len(duplicates) > 0 {
+		result.Set("duplicates", duplicates)
+	}
+	ordered := make([]interface{}, 0, len(environ))
+	for _, e := range environ {
+		pair := strings.SplitN(e, "=", 2)
+		if len(pair) == 2 {
+			ordered = append(ordered, map[string]interface{}{pair[0]: pair[1]})
+		}
+	}
+	result.Set("ordered", ordered)
+	result.Summary = fmt.Sprintf("Source: %s, Variables: %d, Duplicates: %d", source, len(variables), len(duplicates))
 	return result, nil
 }
 
+// parseEnviron splits KEY=VALUE entries on the first "=" only. The first
+// value of a key wins, as with getenv, and keys set more than once are
+// returned with all their values.
+func parseEnviron(environ []string) (map[string]interface{}, map[string]interface{}) {
+	variables := map[string]interface{}{}
+	values := map[string][]string{}
+	for _, e := range environ {
+		pair := strings.SplitN(e, "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		if _, ok := variables[pair[0]]; !ok {
+			variables[pair[0]] = pair[1]
+		}
+		values[pair[0]] = append(values[pair[0]], pair[1])
+	}
+	duplicates := map[string]interface{}{}
+	for key, vals := range values {
+		if len(vals) > 1 {
+			duplicates[key] = vals
+		}
+	}
+	return variables, duplicates
+}
 
 func HostInfoFunc(_ context.Context) (*Result, error) {
 	result := NewResult("host-info")
@@ -52,10 +113,9 @@ func HostInfoFunc(_ context.Context) (*Result, error) {
 	if err != nil {
 		return nil, err
 	}
-	s := structs.New(info)
-	for k, v := range s.Map() {
-		result.Data[k] = fmt.Sprintf("%v", v)
-	}
+	result.Data = StructData(info)
+	result.AddUnit("Uptime", UnitSeconds)
+	result.AddUnit("BootTime", UnitTimestamp)
 	return result, nil
 }
 
@@ -65,12 +125,14 @@ func CpuInfoFunc(_ context.Context) (*Result, error) {
 	if err != nil {
 		return nil, err
 	}
+	cpus := make([]interface{}, 0, len(infos))
 	for _, info := range infos {
-		s := structs.New(info)
-		for k, v := range s.Map() {
-			result.Data[k] = fmt.Sprintf("%v", v)
-		}
+		cpus = append(cpus, StructData(info))
 	}
+	result.Set("count", len(infos))
+	result.Set("cpus", cpus)
+	result.AddUnit("cpus.Mhz", UnitMHz)
+	result.AddUnit("cpus.CacheSize", UnitKiloBytes)
 	return result, nil
 }
 
@@ -81,9 +143,13 @@ func MemoryInfoFunc(_ context.Context) (*Result, error) {
 		return nil, err
 	}
 	result.Summary = fmt.Sprintf("Total: %v, Free:%v, UsedPercent:%f%%", info.Total, info.Free, info.UsedPercent)
-	s := structs.New(info)
-	for k, v := range s.Map() {
-		result.Data[k] = fmt.Sprintf("%v", v)
+	result.Data = StructData(info)
+	for k := range result.Data {
+		if strings.HasSuffix(k, "Percent") {
+			result.AddUnit(k, UnitPercent)
+		} else {
+			result.AddUnit(k, UnitBytes)
+		}
 	}
 	return result, nil
 }
@@ -94,10 +160,7 @@ func LoadAvgFunc(_ context.Context) (*Result, error) {
 	if err != nil {
 		return nil, err
 	}
-	s := structs.New(info)
-	for k, v := range s.Map() {
-		result.Data[k] = fmt.Sprintf("%v", v)
-	}
+	result.Data = StructData(info)
 	return result, nil
 }
 
@@ -109,26 +172,37 @@ func NetworkInfoFunc(_ context.Context) (*Result, error) {
 	}
 	for _, f := range faces {
 		addrs, _ := f.Addrs()
-		val := fmt.Sprintf("Index:%d Flags:%v HardwareAddr:%s Addrs:%v", f.Index, f.Flags, f.HardwareAddr.String(), addrs)
-		result.Data[f.Name] = val
+		addrList := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			addrList = append(addrList, addr.String())
+		}
+		result.Set(f.Name, map[string]interface{}{
+			"Index":        f.Index,
+			"MTU":          f.MTU,
+			"Flags":        f.Flags.String(),
+			"HardwareAddr": f.HardwareAddr.String(),
+			"Addrs":        addrList,
+		})
 	}
 	return result, nil
 }
 
 func RequestInfoFunc(ctx context.Context) (*Result, error) {
 	result := NewResult("request-info")
-	request := ctx.Value("request")
-	if httpRequest, ok := request.(*http.Request); ok {
-		result.Data["RemoteAddr"] = httpRequest.RemoteAddr
+	if httpRequest := Request(ctx); httpRequest != nil {
+		result.Set("RemoteAddr", httpRequest.RemoteAddr)
+		if clientIP := ClientIP(ctx); clientIP != "" {
+			result.Set("ClientIP", clientIP)
+		}
+		header := map[string]interface{}{}
 		for key, vals := range httpRequest.Header {
-			var value string
 			if len(vals) == 1 {
-				value = vals[0]
+				header[key] = vals[0]
 			} else {
-				value = fmt.Sprintf("%v", vals)
+				header[key] = vals
 			}
-			result.Data["Header"+key] = value
 		}
+		result.Set("Header", header)
 	}
 	return result, nil
 }
@@ -4,7 +4,11 @@ import (
 	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"testing"
+	"time"
 )
 
 func TestEnvFunc(t *testing.T) {
@@ -15,6 +19,21 @@ func TestEnvFunc(t *testing.T) {
 	assert.True(t, len(r.Data) > 0)
 }
 
+func TestEnvFuncOrdered(t *testing.T) {
+	defer os.RemoveAll(fakeProc(t))
+	r, err := EnvFunc(WithParams(context.Background(), url.Values{"pid": {"42"}}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"PATH": "/bin"},
+		map[string]interface{}{"HOME": "/root"},
+		map[string]interface{}{"PATH": "/usr/bin"},
+		map[string]interface{}{"EMPTY": ""},
+	}, r.Data["ordered"])
+	assert.Equal(t, map[string]interface{}{"PATH": []string{"/bin", "/usr/bin"}}, r.Data["duplicates"])
+}
+
 func TestHostInfoFunc(t *testing.T) {
 	ctx := context.Background()
 	r, err := HostInfoFunc(ctx)
@@ -23,7 +42,74 @@ func TestHostInfoFunc(t *testing.T) {
 	assert.True(t, len(r.Data) > 0)
 }
 
+func TestRequestInfoFunc(t *testing.T) {
+	r, err := RequestInfoFunc(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, r.Data)
+
+	req := httptest.NewRequest("GET", "/request-info", nil)
+	req.Header.Set("X-Test", "1")
+	ctx := WithRequestID(WithRequest(context.Background(), req), "REQ-1")
+	assert.Equal(t, "REQ-1", RequestID(ctx))
+	r, err = RequestInfoFunc(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, req.RemoteAddr, r.Data["RemoteAddr"])
+	assert.Equal(t, "1", r.Data["Header"].(map[string]interface{})["X-Test"])
+}
+
 func TestProbeFuncs(t *testing.T) {
 	//ctx := context.Background()
 	//probe.probeFuncs
 }
+
+func TestDiskUsageFunc(t *testing.T) {
+	ctx := context.Background()
+	r, err := DiskUsageFunc(ctx)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, r.Data["mounts"])
+	assert.Equal(t, UnitBytes, r.Unit("mounts.0.Total"))
+}
+
+func TestNetworkIOFunc(t *testing.T) {
+	ctx := WithParams(context.Background(), url.Values{"interval": {"10ms"}})
+	r, err := NetworkIOFunc(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "10ms", r.Data["interval"])
+	assert.NotEmpty(t, r.Data["interfaces"])
+	assert.Equal(t, UnitBytesPerSecond, r.Unit("interfaces.0.BytesRecvPerSec"))
+
+	// Without interval only the counters are reported, at once.
+	start := time.Now()
+	r, err = NetworkIOFunc(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	assert.Nil(t, r.Data["interval"])
+	iface := r.Data["interfaces"].([]interface{})[0].(map[string]interface{})
+	assert.Contains(t, iface, "BytesRecv")
+	assert.NotContains(t, iface, "BytesRecvPerSec")
+
+	deadline, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, interval := range []string{"0", "-1s", "11s", "2s", "soon"} {
+		_, err = NetworkIOFunc(WithParams(deadline, url.Values{"interval": {interval}}))
+		assert.IsType(t, &ParamError{}, err, interval)
+	}
+}
+
+func TestParseEnviron(t *testing.T) {
+	variables, duplicates := parseEnviron([]string{
+		"DSN=postgres://u@h/db?sslmode=require",
+		"OPTS=-Da=b -Dc=d",
+		"PATH=/bin",
+		"PATH=/usr/bin",
+		"EMPTY=",
+		"broken",
+	})
+	assert.Equal(t, map[string]interface{}{
+		"DSN":   "postgres://u@h/db?sslmode=require",
+		"OPTS":  "-Da=b -Dc=d",
+		"PATH":  "/bin",
+		"EMPTY": "",
+	}, variables)
+	assert.Equal(t, map[string]interface{}{"PATH": []string{"/bin", "/usr/bin"}}, duplicates)
+}
@@ -0,0 +1,173 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/shirou/gopsutil/net"
+)
+
+// maxSampleInterval bounds the interval parameter of network-io.
+const maxSampleInterval = 10 * time.Second
+
+// netProtocols are the protocols reported by the network-io probe.
+var netProtocols = []string{"ip", "tcp", "udp", "icmp"}
+
+// protoGauges are protocol counters which are current values, not totals, so
+// no rate is computed for them.
+var protoGauges = map[string]bool{
+	"Forwarding":   true,
+	"DefaultTTL":   true,
+	"RtoAlgorithm": true,
+	"RtoMin":       true,
+	"RtoMax":       true,
+	"MaxConn":      true,
+	"CurrEstab":    true,
+}
+
+type netSample struct {
+	time      time.Time
+	ifaces    []net.IOCountersStat
+	protocols []net.ProtoCountersStat
+}
+
+func takeNetSample() (*netSample, error) {
+	ifaces, err := net.IOCounters(true)
+	if err != nil {
+		return nil, err
+	}
+	protocols, err := net.ProtoCounters(netProtocols)
+	if err != nil {
+		return nil, err
+	}
+	return &netSample{time: time.Now(), ifaces: ifaces, protocols: protocols}, nil
+}
+
+// NetworkIOFunc reports interface and protocol counters and conntrack table
+// usage. Rates are only computed when the interval parameter asks for them,
+// over a sample of that length, so the aggregate and metrics runs do not
+// block.
+func NetworkIOFunc(ctx context.Context) (*Result, error) {
+	result := NewResult("network-io")
+	interval, err := durationParam(ctx, "interval", 0)
+	if err != nil {
+		return nil, err
+	}
+	if interval > maxSampleInterval {
+		return nil, paramErrorf("Invalid interval [%s], at most %s", interval, maxSampleInterval)
+	}
+	if deadline, ok := ctx.Deadline(); ok && interval >= time.Until(deadline) {
+		return nil, paramErrorf("Invalid interval [%s], it exceeds the probe timeout", interval)
+	}
+	first, err := takeNetSample()
+	if err != nil {
+		return nil, err
+	}
+	second := first
+	if interval > 0 {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if second, err = takeNetSample(); err != nil {
+			return nil, err
+		}
+	}
+	seconds := second.time.Sub(first.time).Seconds()
+
+	previous := map[string]net.IOCountersStat{}
+	for _, iface := range first.ifaces {
+		previous[iface.Name] = iface
+	}
+	sort.Slice(second.ifaces, func(i, j int) bool {
+		return second.ifaces[i].Name < second.ifaces[j].Name
+	})
+	var recv, sent float64
+	var recvTotal, sentTotal uint64
+	ifaces := make([]interface{}, 0, len(second.ifaces))
+	for _, iface := range second.ifaces {
+		data := StructData(iface)
+		recvTotal += iface.BytesRecv
+		sentTotal += iface.BytesSent
+		if prev, ok := previous[iface.Name]; ok && interval > 0 {
+			data["BytesRecvPerSec"] = rate(prev.BytesRecv, iface.BytesRecv, seconds)
+			data["BytesSentPerSec"] = rate(prev.BytesSent, iface.BytesSent, seconds)
+			data["PacketsRecvPerSec"] = rate(prev.PacketsRecv, iface.PacketsRecv, seconds)
+			data["PacketsSentPerSec"] = rate(prev.PacketsSent, iface.PacketsSent, seconds)
+			data["ErrorsPerSec"] = rate(prev.Errin+prev.Errout, iface.Errin+iface.Errout, seconds)
+			data["DropsPerSec"] = rate(prev.Dropin+prev.Dropout, iface.Dropin+iface.Dropout, seconds)
+			recv += rate(prev.BytesRecv, iface.BytesRecv, seconds)
+			sent += rate(prev.BytesSent, iface.BytesSent, seconds)
+		}
+		ifaces = append(ifaces, data)
+	}
+	if interval > 0 {
+		result.Set("interval", interval.String())
+	}
+	result.Set("interfaces", ifaces)
+	for _, key := range []string{"BytesRecv", "BytesSent"} {
+		result.AddUnit("interfaces."+key, UnitBytes)
+		result.AddUnit("interfaces."+key+"PerSec", UnitBytesPerSecond)
+	}
+	for _, key := range []string{"PacketsRecvPerSec", "PacketsSentPerSec", "ErrorsPerSec", "DropsPerSec"} {
+		result.AddUnit("interfaces."+key, UnitPerSecond)
+	}
+	for _, key := range []string{"BytesRecv", "BytesSent", "PacketsRecv", "PacketsSent", "Errin", "Errout", "Dropin", "Dropout", "Fifoin", "Fifoout"} {
+		result.AddCounter("interfaces." + key)
+	}
+
+	previousStats := map[string]map[string]int64{}
+	for _, proto := range first.protocols {
+		previousStats[proto.Protocol] = proto.Stats
+	}
+	protocols := map[string]interface{}{}
+	for _, proto := range second.protocols {
+		rates := map[string]interface{}{}
+		for key, value := range proto.Stats {
+			if protoGauges[key] {
+				continue
+			}
+			result.AddCounter("protocols." + proto.Protocol + ".counters." + key)
+			if prev, ok := previousStats[proto.Protocol][key]; ok {
+				rates[key] = rate(uint64(prev), uint64(value), seconds)
+			}
+		}
+		protocols[proto.Protocol] = map[string]interface{}{"counters": proto.Stats}
+		if interval > 0 {
+			protocols[proto.Protocol].(map[string]interface{})["rates"] = rates
+		}
+	}
+	result.Set("protocols", protocols)
+
+	if filters, err := net.FilterCounters(); err == nil && len(filters) > 0 {
+		conntrack := map[string]interface{}{
+			"Count": filters[0].ConnTrackCount,
+			"Max":   filters[0].ConnTrackMax,
+		}
+		if filters[0].ConnTrackMax > 0 {
+			conntrack["UsedPercent"] = 100 * float64(filters[0].ConnTrackCount) / float64(filters[0].ConnTrackMax)
+		}
+		result.Set("conntrack", conntrack)
+		result.AddUnit("conntrack.UsedPercent", UnitPercent)
+	} else if err != nil {
+		result.Set("conntrack", map[string]interface{}{"Error": err.Error()})
+	}
+
+	if interval > 0 {
+		result.Summary = fmt.Sprintf("Interval: %s, Recv: %.0f bytes/s, Sent: %.0f bytes/s", interval, recv, sent)
+	} else {
+		result.Summary = fmt.Sprintf("Recv: %d bytes, Sent: %d bytes", recvTotal, sentTotal)
+	}
+	return result, nil
+}
+
+// rate is the per second increase of a counter, zero if it was reset.
+func rate(previous, current uint64, seconds float64) float64 {
+	if current < previous || seconds <= 0 {
+		return 0
+	}
+	return float64(current-previous) / seconds
+}
@@ -0,0 +1,127 @@
+package probe
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+type paramsKey struct{}
+
+// WithParams returns a copy of ctx carrying the parameters of a probe run,
+// such as the query of the HTTP request which asked for it.
+func WithParams(ctx context.Context, params url.Values) context.Context {
+	return context.WithValue(ctx, paramsKey{}, params)
+}
+
+// Params returns the parameters carried by ctx, never nil.
+func Params(ctx context.Context) url.Values {
+	if params, ok := ctx.Value(paramsKey{}).(url.Values); ok && params != nil {
+		return params
+	}
+	return url.Values{}
+}
+
+type requestKey struct{}
+
+// WithRequest returns a copy of ctx carrying the HTTP request which asked
+// for the probe run.
+func WithRequest(ctx context.Context, req *http.Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// Request returns the HTTP request carried by ctx, nil when the run was not
+// asked for over HTTP.
+func Request(ctx context.Context) *http.Request {
+	req, _ := ctx.Value(requestKey{}).(*http.Request)
+	return req
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the ID of the HTTP request,
+// as logged and sent in the X-RequestID header.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, "" when there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type clientIPKey struct{}
+
+// WithClientIP returns a copy of ctx carrying the address of the client,
+// which differs from the remote address of the request behind a proxy.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIP returns the client address carried by ctx, "" when there is none.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+type filterKey struct{}
+
+// WithFilter returns a copy of ctx under which DoProbe only runs the probes
+// accepted by filter, and by the filters ctx already carries. The others are
+// treated as not registered.
+func WithFilter(ctx context.Context, filter func(name string) bool) context.Context {
+	if parent, ok := ctx.Value(filterKey{}).(func(string) bool); ok {
+		child := filter
+		filter = func(name string) bool {
+			return parent(name) && child(name)
+		}
+	}
+	return context.WithValue(ctx, filterKey{}, filter)
+}
+
+// accepted reports whether the filters carried by ctx accept probe name.
+func accepted(ctx context.Context, name string) bool {
+	filter, ok := ctx.Value(filterKey{}).(func(string) bool)
+	return !ok || filter(name)
+}
+
+// durationParam parses parameter name as a duration such as "500ms", a plain
+// number is taken as seconds. It must be positive.
+func durationParam(ctx context.Context, name string, defaultValue time.Duration) (time.Duration, error) {
+	value := Params(ctx).Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if seconds, serr := strconv.ParseFloat(value, 64); serr == nil {
+		d, err = time.Duration(seconds*float64(time.Second)), nil
+	}
+	if err != nil || d <= 0 {
+		return 0, paramErrorf("Invalid %s [%s]", name, value)
+	}
+	return d, nil
+}
+
+// hostProc joins elem to the proc filesystem root, which HOST_PROC overrides
+// the same way it does for gopsutil.
+func hostProc(elem ...string) string {
+	root := os.Getenv("HOST_PROC")
+	if root == "" {
+		root = "/proc"
+	}
+	return filepath.Join(append([]string{root}, elem...)...)
+}
+
+// hostSys joins elem to the sys filesystem root, overridden by HOST_SYS.
+func hostSys(elem ...string) string {
+	root := os.Getenv("HOST_SYS")
+	if root == "" {
+		root = "/sys"
+	}
+	return filepath.Join(append([]string{root}, elem...)...)
+}
@@ -4,58 +4,326 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/url"
 	"sort"
 	"sync"
+	"time"
 )
 
 var single = newProbe()
 
+// Defaults for aggregate runs, see SetConcurrency and SetTimeout.
+const (
+	DefaultConcurrency = 4
+	DefaultTimeout     = 5 * time.Second
+)
+
 func newProbe() *Probe {
-	return &Probe{probeFuncs: map[string]ProbeFunc{}, lock: sync.RWMutex{}}
+	return &Probe{
+		probeFuncs:  map[string]ProbeFunc{},
+		onDemand:    map[string]ProbeFunc{},
+		lock:        sync.RWMutex{},
+		concurrency: DefaultConcurrency,
+		timeout:     DefaultTimeout,
+		redactor:    NewRedactor(nil, nil, ""),
+	}
+}
+
+// Result status values.
+const (
+	StatusOK      = "ok"
+	StatusError   = "error"
+	StatusTimeout = "timeout"
+)
+
+// Report status values for an aggregate run.
+const (
+	ReportOK      = "ok"
+	ReportPartial = "partial"
+	ReportFailed  = "failed"
+)
+
+// Report is the result of running every registered probe. A failing probe
+// does not abort the run, it is reported as an error entry instead.
+type Report struct {
+	Status  string    `json:"status"`
+	Failed  int       `json:"failed"`
+	Results []*Result `json:"results"`
 }
 
-type Result struct {
-	Name string            `json:"name"`
-	Summary string 	`json:"summary"`
-	Data map[string]string `json:"data"`
+func newReport(results []*Result) *Report {
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	report := &Report{Status: ReportOK, Results: results}
+	for _, result := range results {
+		if result.Status != StatusOK {
+			report.Failed++
+		}
+	}
+	if report.Failed > 0 {
+		if report.Failed == len(results) {
+			report.Status = ReportFailed
+		} else {
+			report.Status = ReportPartial
+		}
+	}
+	return report
 }
 
-func NewResult(name string) *Result {
-	return &Result{Name: name, Data: map[string]string{}}
+// Flat returns a copy of r with every result flattened.
+func (r *Report) Flat() *Report {
+	flat := *r
+	flat.Results = make([]*Result, len(r.Results))
+	for i, result := range r.Results {
+		flat.Results[i] = result.Flat()
+	}
+	return &flat
 }
 
 type ProbeFunc func(ctx context.Context) (*Result, error)
 
+// NoSuchProbeError is returned by DoProbe for a name which is not registered
+// or is disabled.
+type NoSuchProbeError struct {
+	Name string
+}
+
+func (e *NoSuchProbeError) Error() string {
+	return fmt.Sprintf("No such probe [%s]", e.Name)
+}
+
+// ParamError is returned by a probe for a missing or invalid parameter.
+type ParamError struct {
+	Message string
+}
+
+func (e *ParamError) Error() string {
+	return e.Message
+}
+
+func paramErrorf(format string, args ...interface{}) error {
+	return &ParamError{Message: fmt.Sprintf(format, args...)}
+}
+
+// NotFoundError is returned by a probe when what it was asked about, such
+// as a process, does not exist.
+type NotFoundError struct {
+	Message string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Message
+}
+
 type Probe struct {
-	probeFuncs map[string]ProbeFunc
-	lock       sync.RWMutex
+	probeFuncs  map[string]ProbeFunc
+	onDemand    map[string]ProbeFunc
+	lock        sync.RWMutex
+	concurrency int
+	timeout     time.Duration
+	redactor    *Redactor
+	enabled     map[string]bool
+	disabled    map[string]bool
+	options     map[string]Options
 }
 
-func (p *Probe) DoProbe(ctx context.Context, name string) (interface{}, error) {
+// Options are the settings of a single probe.
+type Options struct {
+	// Timeout overrides the deadline of the probe when not zero.
+	Timeout time.Duration
+	// Params are defaults for the parameters the request leaves out.
+	Params url.Values
+}
+
+// Config holds every setting of a Probe, so a reload applies them at once.
+type Config struct {
+	Concurrency int
+	Timeout     time.Duration
+	// Enabled lists the only probes which run, all when empty.
+	Enabled  []string
+	Disabled []string
+	Options  map[string]Options
+	Redactor *Redactor
+}
+
+// Configure replaces the settings of p.
+func (p *Probe) Configure(config Config) {
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	var enabled map[string]bool
+	if len(config.Enabled) > 0 {
+		enabled = map[string]bool{}
+		for _, name := range config.Enabled {
+			enabled[name] = true
+		}
+	}
+	disabled := map[string]bool{}
+	for _, name := range config.Disabled {
+		disabled[name] = true
+	}
+	p.lock.Lock()
+	p.concurrency = config.Concurrency
+	p.timeout = config.Timeout
+	p.redactor = config.Redactor
+	p.enabled = enabled
+	p.disabled = disabled
+	p.options = config.Options
+	p.lock.Unlock()
+}
+
+// isEnabled must be called with the lock held.
+func (p *Probe) isEnabled(name string) bool {
+	return !p.disabled[name] && (p.enabled == nil || p.enabled[name])
+}
+
+// Names returns the names of all registered probes, on-demand ones included.
+func (p *Probe) Names() []string {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
+	names := make([]string, 0, len(p.probeFuncs)+len(p.onDemand))
+	for name := range p.probeFuncs {
+		names = append(names, name)
+	}
+	for name := range p.onDemand {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
 
+func (p *Probe) DoProbe(ctx context.Context, name string) (interface{}, error) {
+	p.lock.RLock()
+	timeout := p.timeout
+	concurrency := p.concurrency
+	redactor := p.redactor
+	options := p.options
 	if name != "" {
 		probeFunc, ok := p.probeFuncs[name]
 		if !ok {
-			return nil, fmt.Errorf("No such probe [%s]", name)
+			probeFunc, ok = p.onDemand[name]
 		}
-		return probeFunc(ctx)
-	} else {
-		var results []*Result
-		for k, probeFunc := range p.probeFuncs {
-			result, err := probeFunc(ctx)
-			if err != nil {
-				log.Fatalf("Probe %s error: %s \n", k, err.Error())
-			} else {
-				results = append(results, result)
+		ok = ok && p.isEnabled(name) && accepted(ctx, name)
+		p.lock.RUnlock()
+		if !ok {
+			return nil, &NoSuchProbeError{Name: name}
+		}
+		result := runProbe(ctx, name, probeFunc, timeout, options[name])
+		if result.Status != StatusOK {
+			return nil, result.err
+		}
+		redactor.Redact(result)
+		return result, nil
+	}
+	probeFuncs := make(map[string]ProbeFunc, len(p.probeFuncs))
+	for k, probeFunc := range p.probeFuncs {
+		if p.isEnabled(k) && accepted(ctx, k) {
+			probeFuncs[k] = probeFunc
+		}
+	}
+	p.lock.RUnlock()
+
+	results := make([]*Result, 0, len(probeFuncs))
+	resultCh := make(chan *Result)
+	sem := make(chan struct{}, concurrency)
+	for k, probeFunc := range probeFuncs {
+		go func(name string, probeFunc ProbeFunc) {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				resultCh <- runProbe(ctx, name, probeFunc, timeout, options[name])
+			case <-ctx.Done():
+				resultCh <- errorResult(name, ctx.Err())
 			}
+		}(k, probeFunc)
+	}
+	for range probeFuncs {
+		result := <-resultCh
+		redactor.Redact(result)
+		results = append(results, result)
+	}
+	return newReport(results), nil
+}
+
+// runProbe runs probeFunc with a deadline of timeout, unless its options
+// override it, and always returns a result, converting a failure into an
+// error entry. A probe which ignores its context is abandoned once the
+// deadline passes.
+func runProbe(ctx context.Context, name string, probeFunc ProbeFunc, timeout time.Duration, options Options) *Result {
+	start := time.Now()
+	if options.Timeout > 0 {
+		timeout = options.Timeout
+	}
+	if len(options.Params) > 0 {
+		params := url.Values{}
+		for k, v := range options.Params {
+			params[k] = v
 		}
-		sort.Slice(results, func(i, j int) bool {
-			return results[i].Name < results[j].Name
-		})
-		return results, nil
+		for k, v := range Params(ctx) {
+			params[k] = v
+		}
+		ctx = WithParams(ctx, params)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	done := make(chan *Result, 1)
+	go func() {
+		result, err := probeFunc(ctx)
+		if err != nil {
+			result = errorResult(name, err)
+		}
+		done <- result
+	}()
+	var result *Result
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		result = errorResult(name, ctx.Err())
+	}
+	if result.Status != StatusOK {
+		log.Printf("Probe %s %s: %s \n", name, result.Status, result.Error)
+	}
+	result.Duration = time.Since(start).String()
+	return result
+}
+
+// errorResult is NewErrorResult which marks an exceeded deadline as a timeout.
+func errorResult(name string, err error) *Result {
+	result := NewErrorResult(name, err)
+	if err == context.DeadlineExceeded {
+		result.Status = StatusTimeout
 	}
+	return result
+}
+
+// SetConcurrency limits how many probes an aggregate run executes at once.
+func (p *Probe) SetConcurrency(concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	p.lock.Lock()
+	p.concurrency = concurrency
+	p.lock.Unlock()
+}
+
+// SetTimeout sets the deadline of every probe run, zero disables it. An
+// earlier deadline on the caller's context still applies.
+func (p *Probe) SetTimeout(timeout time.Duration) {
+	p.lock.Lock()
+	p.timeout = timeout
+	p.lock.Unlock()
+}
+
+// SetRedactor sets the redactor applied to every result, nil disables
+// redaction.
+func (p *Probe) SetRedactor(redactor *Redactor) {
+	p.lock.Lock()
+	p.redactor = redactor
+	p.lock.Unlock()
 }
 
 func (p *Probe) Register(name string, probeFunc ProbeFunc) {
@@ -64,6 +332,34 @@ func (p *Probe) Register(name string, probeFunc ProbeFunc) {
 	p.lock.Unlock()
 }
 
+// RegisterOnDemand registers a probe which only runs when asked for by name,
+// usually with parameters, and is left out of aggregate runs.
+func (p *Probe) RegisterOnDemand(name string, probeFunc ProbeFunc) {
+	p.lock.Lock()
+	p.onDemand[name] = probeFunc
+	p.lock.Unlock()
+}
+
 func DoProbe(ctx context.Context, name string) (interface{}, error) {
 	return single.DoProbe(ctx, name)
 }
+
+func SetConcurrency(concurrency int) {
+	single.SetConcurrency(concurrency)
+}
+
+func SetTimeout(timeout time.Duration) {
+	single.SetTimeout(timeout)
+}
+
+func SetRedactor(redactor *Redactor) {
+	single.SetRedactor(redactor)
+}
+
+func Configure(config Config) {
+	single.Configure(config)
+}
+
+func Names() []string {
+	return single.Names()
+}
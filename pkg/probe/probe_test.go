@@ -0,0 +1,81 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoProbePartial(t *testing.T) {
+	p := newProbe()
+	p.Register("good", StatusFunc)
+	p.Register("bad", func(_ context.Context) (*Result, error) {
+		return nil, errors.New("boom")
+	})
+	r, err := p.DoProbe(context.Background(), "")
+	assert.NoError(t, err)
+	report := r.(*Report)
+	assert.Equal(t, ReportPartial, report.Status)
+	assert.Equal(t, 1, report.Failed)
+	assert.Len(t, report.Results, 2)
+	assert.Equal(t, "bad", report.Results[0].Name)
+	assert.Equal(t, StatusError, report.Results[0].Status)
+	assert.Equal(t, "boom", report.Results[0].Error)
+	assert.Equal(t, StatusOK, report.Results[1].Status)
+}
+
+func TestDoProbeTimeout(t *testing.T) {
+	p := newProbe()
+	p.SetTimeout(50 * time.Millisecond)
+	p.SetConcurrency(2)
+	p.Register("status", StatusFunc)
+	for _, name := range []string{"slow1", "slow2", "slow3"} {
+		p.Register(name, func(ctx context.Context) (*Result, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+	}
+	start := time.Now()
+	r, err := p.DoProbe(context.Background(), "")
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+	report := r.(*Report)
+	assert.Equal(t, ReportPartial, report.Status)
+	assert.Equal(t, 3, report.Failed)
+	for _, result := range report.Results[:3] {
+		assert.Equal(t, StatusTimeout, result.Status)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	p := newProbe()
+	p.Register("status", StatusFunc)
+	p.Register("interval", func(ctx context.Context) (*Result, error) {
+		result := NewResult("interval")
+		result.Set("interval", Params(ctx).Get("interval"))
+		return result, nil
+	})
+	p.Configure(Config{
+		Concurrency: 1,
+		Disabled:    []string{"status"},
+		Options: map[string]Options{
+			"interval": {Params: url.Values{"interval": {"3"}}},
+		},
+	})
+	_, err := p.DoProbe(context.Background(), "status")
+	assert.IsType(t, &NoSuchProbeError{}, err)
+	r, err := p.DoProbe(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Len(t, r.(*Report).Results, 1)
+	r, err = p.DoProbe(context.Background(), "interval")
+	assert.NoError(t, err)
+	assert.Equal(t, "3", r.(*Result).Data["interval"])
+	ctx := WithParams(context.Background(), url.Values{"interval": {"5"}})
+	r, err = p.DoProbe(ctx, "interval")
+	assert.NoError(t, err)
+	assert.Equal(t, "5", r.(*Result).Data["interval"])
+}
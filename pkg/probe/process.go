@@ -0,0 +1,218 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/net"
+	"github.com/shirou/gopsutil/process"
+)
+
+func ProcessesFunc(ctx context.Context) (*Result, error) {
+	result := NewResult("processes")
+	pids, err := process.Pids()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	list := make([]interface{}, 0, len(pids))
+	for _, pid := range pids {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		p, err := process.NewProcess(pid)
+		if err != nil {
+			continue
+		}
+		list = append(list, processData(p))
+	}
+	result.Summary = fmt.Sprintf("Processes: %d", len(list))
+	result.Set("count", len(list))
+	result.Set("processes", list)
+	addProcessUnits(result, "processes.")
+	return result, nil
+}
+
+// ProcessFunc reports the details of the process given by the pid parameter.
+func ProcessFunc(ctx context.Context) (*Result, error) {
+	result := NewResult("process")
+	pid, err := strconv.ParseInt(Params(ctx).Get("pid"), 10, 32)
+	if err != nil {
+		return nil, paramErrorf("Invalid pid [%s]", Params(ctx).Get("pid"))
+	}
+	if _, err := os.Stat(hostProc(strconv.Itoa(int(pid)))); err != nil {
+		return nil, &NotFoundError{Message: fmt.Sprintf("No such process [%d]", pid)}
+	}
+	p, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return nil, err
+	}
+	result.Data = processData(p)
+	addProcessUnits(result, "")
+	if exe, err := p.Exe(); err == nil {
+		result.Set("Exe", exe)
+	}
+	if cwd, err := p.Cwd(); err == nil {
+		result.Set("Cwd", cwd)
+	}
+	if mem, err := p.MemoryInfo(); err == nil {
+		result.Set("Memory", StructData(mem))
+		for _, key := range []string{"RSS", "VMS", "Swap"} {
+			result.AddUnit("Memory."+key, UnitBytes)
+		}
+	}
+	if environ, err := readEnviron(p.Pid); err == nil {
+		env, _ := parseEnviron(environ)
+		result.Set("Environ", env)
+	}
+	if limits, err := readLimits(p.Pid); err == nil {
+		result.Set("Limits", limits)
+	}
+	if threads, err := readThreads(p.Pid); err == nil {
+		result.Set("Threads", threads)
+	}
+	if conns, err := net.ConnectionsPid("all", p.Pid); err == nil {
+		list := make([]interface{}, 0, len(conns))
+		for _, conn := range conns {
+			list = append(list, connectionData(conn))
+		}
+		result.Set("Connections", list)
+	}
+	result.Summary = fmt.Sprintf("PID: %d, Name: %v", pid, result.Data["Name"])
+	return result, nil
+}
+
+// processData collects the fields of the processes listing, skipping the ones
+// which cannot be read.
+func processData(p *process.Process) map[string]interface{} {
+	data := map[string]interface{}{"PID": p.Pid}
+	if ppid, err := p.Ppid(); err == nil {
+		data["PPID"] = ppid
+	}
+	if name, err := p.Name(); err == nil {
+		data["Name"] = name
+	}
+	if cmdline, err := p.Cmdline(); err == nil {
+		data["Cmdline"] = cmdline
+	}
+	if status, err := p.Status(); err == nil {
+		data["Status"] = status
+	}
+	if username, err := p.Username(); err == nil {
+		data["User"] = username
+	} else if uids, err := p.Uids(); err == nil && len(uids) > 0 {
+		data["User"] = strconv.Itoa(int(uids[0]))
+	}
+	if mem, err := p.MemoryInfo(); err == nil {
+		data["RSS"] = mem.RSS
+	}
+	if fds, err := p.NumFDs(); err == nil {
+		data["NumFDs"] = fds
+	}
+	if threads, err := p.NumThreads(); err == nil {
+		data["NumThreads"] = threads
+	}
+	createTime, err := p.CreateTime()
+	if err == nil {
+		start := time.Unix(0, createTime*int64(time.Millisecond))
+		data["StartTime"] = start.Format(time.RFC3339)
+		// Average over the process lifetime, as ps reports it.
+		if times, err := p.Times(); err == nil {
+			if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+				data["CPUPercent"] = 100 * (times.User + times.System) / elapsed
+			}
+		}
+	}
+	return data
+}
+
+func addProcessUnits(result *Result, prefix string) {
+	result.AddUnit(prefix+"RSS", UnitBytes)
+	result.AddUnit(prefix+"CPUPercent", UnitPercent)
+}
+
+// readEnviron returns the environment of process pid in its original order.
+func readEnviron(pid int32) ([]string, error) {
+	b, err := ioutil.ReadFile(hostProc(strconv.Itoa(int(pid)), "environ"))
+	if os.IsNotExist(err) {
+		return nil, &NotFoundError{Message: fmt.Sprintf("No such process [%d]", pid)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var environ []string
+	for _, e := range bytes.Split(b, []byte{0}) {
+		if len(e) > 0 {
+			environ = append(environ, string(e))
+		}
+	}
+	return environ, nil
+}
+
+// readLimits parses /proc/<pid>/limits, whose columns are aligned to the
+// header line.
+func readLimits(pid int32) (map[string]interface{}, error) {
+	f, err := os.Open(hostProc(strconv.Itoa(int(pid)), "limits"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	limits := map[string]interface{}{}
+	scanner := bufio.NewScanner(f)
+	var soft, hard, units int
+	for scanner.Scan() {
+		line := scanner.Text()
+		if soft == 0 {
+			soft = strings.Index(line, "Soft Limit")
+			hard = strings.Index(line, "Hard Limit")
+			units = strings.Index(line, "Units")
+			if soft <= 0 || hard <= soft || units <= hard {
+				return nil, fmt.Errorf("Unexpected limits header: %s", line)
+			}
+			continue
+		}
+		if len(line) < units {
+			line += strings.Repeat(" ", units-len(line))
+		}
+		limits[strings.TrimSpace(line[:soft])] = map[string]interface{}{
+			"Soft":  strings.TrimSpace(line[soft:hard]),
+			"Hard":  strings.TrimSpace(line[hard:units]),
+			"Units": strings.TrimSpace(line[units:]),
+		}
+	}
+	return limits, scanner.Err()
+}
+
+// readThreads lists the threads of process pid with their names.
+func readThreads(pid int32) ([]interface{}, error) {
+	dir := hostProc(strconv.Itoa(int(pid)), "task")
+	tids, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, tid := range tids {
+		if id, err := strconv.Atoi(tid.Name()); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	threads := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		thread := map[string]interface{}{"TID": id}
+		if comm, err := ioutil.ReadFile(filepath.Join(dir, strconv.Itoa(id), "comm")); err == nil {
+			thread["Name"] = strings.TrimSpace(string(comm))
+		}
+		threads = append(threads, thread)
+	}
+	return threads, nil
+}
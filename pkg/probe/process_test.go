@@ -0,0 +1,96 @@
+package probe
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProc writes a HOST_PROC tree with process 42 and points HOST_PROC at it.
+func fakeProc(t *testing.T) string {
+	root, err := ioutil.TempDir("", "proc")
+	assert.NoError(t, err)
+	writeFiles(t, root, map[string]string{
+		"42/environ": "PATH=/bin\x00HOME=/root\x00PATH=/usr/bin\x00EMPTY=\x00",
+		"42/limits": "Limit                     Soft Limit           Hard Limit           Units     \n" +
+			"Max cpu time              unlimited            unlimited            seconds   \n" +
+			"Max open files            1024                 4096                 files     \n" +
+			"Max nice priority         0                    0\n",
+		"42/task/42/comm":  "main\n",
+		"42/task/108/comm": "worker\n",
+		"42/task/7/comm":   "gc\n",
+		"42/task/x/comm":   "skipped\n",
+		"43/limits":        "Max open files 1024 4096 files\n",
+	})
+	t.Setenv("HOST_PROC", root)
+	return root
+}
+
+func TestReadEnviron(t *testing.T) {
+	defer os.RemoveAll(fakeProc(t))
+	environ, err := readEnviron(42)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PATH=/bin", "HOME=/root", "PATH=/usr/bin", "EMPTY="}, environ)
+
+	_, err = readEnviron(4242)
+	assert.IsType(t, &NotFoundError{}, err)
+}
+
+func TestReadLimits(t *testing.T) {
+	defer os.RemoveAll(fakeProc(t))
+	limits, err := readLimits(42)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"Max cpu time":      map[string]interface{}{"Soft": "unlimited", "Hard": "unlimited", "Units": "seconds"},
+		"Max open files":    map[string]interface{}{"Soft": "1024", "Hard": "4096", "Units": "files"},
+		"Max nice priority": map[string]interface{}{"Soft": "0", "Hard": "0", "Units": ""},
+	}, limits)
+
+	_, err = readLimits(43)
+	assert.EqualError(t, err, "Unexpected limits header: Max open files 1024 4096 files")
+	_, err = readLimits(4242)
+	assert.Error(t, err)
+}
+
+func TestReadThreads(t *testing.T) {
+	defer os.RemoveAll(fakeProc(t))
+	threads, err := readThreads(42)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"TID": 7, "Name": "gc"},
+		map[string]interface{}{"TID": 42, "Name": "main"},
+		map[string]interface{}{"TID": 108, "Name": "worker"},
+	}, threads)
+
+	_, err = readThreads(4242)
+	assert.Error(t, err)
+}
+
+func TestProcessFuncErrors(t *testing.T) {
+	defer os.RemoveAll(fakeProc(t))
+	for _, pid := range []string{"", "abc", "99999999999"} {
+		_, err := ProcessFunc(WithParams(context.Background(), url.Values{"pid": {pid}}))
+		assert.IsType(t, &ParamError{}, err, pid)
+	}
+	_, err := ProcessFunc(WithParams(context.Background(), url.Values{"pid": {"4242"}}))
+	assert.Equal(t, &NotFoundError{Message: "No such process [4242]"}, err)
+}
+
+func TestEnvFuncPid(t *testing.T) {
+	defer os.RemoveAll(fakeProc(t))
+	r, err := EnvFunc(WithParams(context.Background(), url.Values{"pid": {"42"}}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]interface{}{"PATH": "/bin", "HOME": "/root", "EMPTY": ""}, r.Data["variables"])
+		assert.Equal(t, map[string]interface{}{"PATH": []string{"/bin", "/usr/bin"}}, r.Data["duplicates"])
+		assert.Equal(t, "Source: pid 42, Variables: 3, Duplicates: 1", r.Summary)
+	}
+
+	_, err = EnvFunc(WithParams(context.Background(), url.Values{"pid": {"abc"}}))
+	assert.IsType(t, &ParamError{}, err)
+	_, err = EnvFunc(WithParams(context.Background(), url.Values{"pid": {"4242"}}))
+	assert.IsType(t, &NotFoundError{}, err)
+}
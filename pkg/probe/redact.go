@@ -0,0 +1,154 @@
+package probe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultRedactPatterns are the keys redacted unless allowed explicitly.
+var DefaultRedactPatterns = []string{
+	"*PASSWORD*",
+	"*PASSWD*",
+	"*SECRET*",
+	"*TOKEN*",
+	"*KEY*",
+	"*CREDENTIAL*",
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// CommandLineKeys hold command lines, whose arguments are redacted as
+// key=value and --flag value pairs when the key or flag looks like a secret.
+var CommandLineKeys = []string{"Cmdline"}
+
+// urlPassword matches the password of credentials in a URL, as in a DSN.
+var urlPassword = regexp.MustCompile(`(://[^:/@\s]*:)([^@/\s]+)@`)
+
+// redactedMask replaces a redacted value, followed by a fingerprint of it
+// when the redactor has a key.
+const redactedMask = "******"
+
+// Redactor masks the values of result data whose key matches a deny pattern
+// and no allow pattern. Patterns are shell globs matched against the key of
+// a value at any depth, ignoring case. With a key, the masked value keeps a
+// short HMAC-SHA256 of the original, so equal values can be compared across
+// the pods sharing the key without the fingerprint being guessable offline.
+type Redactor struct {
+	deny  []string
+	allow []string
+	key   []byte
+}
+
+// NewRedactor returns a redactor of DefaultRedactPatterns and deny, which
+// leaves the keys matching allow untouched and fingerprints the masked values
+// with key, not at all when it is empty.
+func NewRedactor(deny []string, allow []string, key string) *Redactor {
+	r := &Redactor{key: []byte(key)}
+	for _, pattern := range append(append([]string{}, DefaultRedactPatterns...), deny...) {
+		r.deny = append(r.deny, strings.ToUpper(pattern))
+	}
+	for _, pattern := range allow {
+		r.allow = append(r.allow, strings.ToUpper(pattern))
+	}
+	return r
+}
+
+// Redact masks the sensitive values of result in place.
+func (r *Redactor) Redact(result *Result) {
+	if r == nil || result == nil {
+		return
+	}
+	r.redactMap(result.Data)
+}
+
+func (r *Redactor) redactMap(data map[string]interface{}) {
+	for key, value := range data {
+		if r.sensitive(key) {
+			data[key] = r.Mask(value)
+			continue
+		}
+		if cmdline, ok := value.(string); ok && isCommandLine(key) {
+			data[key] = r.redactCommandLine(cmdline)
+			continue
+		}
+		r.redactValue(value)
+	}
+}
+
+func (r *Redactor) redactValue(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		r.redactMap(v)
+	case []interface{}:
+		for _, item := range v {
+			r.redactValue(item)
+		}
+	}
+}
+
+func (r *Redactor) sensitive(key string) bool {
+	key = strings.ToUpper(key)
+	for _, pattern := range r.allow {
+		if matched, _ := filepath.Match(pattern, key); matched {
+			return false
+		}
+	}
+	for _, pattern := range r.deny {
+		if matched, _ := filepath.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func isCommandLine(key string) bool {
+	for _, k := range CommandLineKeys {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactCommandLine masks the values of the arguments of cmdline which look
+// like secrets: KEY=value and --flag=value whose name is sensitive, the
+// argument after such a --flag, and passwords in URLs.
+func (r *Redactor) redactCommandLine(cmdline string) string {
+	args := strings.Fields(cmdline)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if pair := strings.SplitN(arg, "=", 2); len(pair) == 2 {
+			if r.sensitive(strings.TrimLeft(pair[0], "-")) {
+				args[i] = pair[0] + "=" + r.Mask(pair[1])
+				continue
+			}
+		} else if strings.HasPrefix(arg, "-") && r.sensitive(strings.TrimLeft(arg, "-")) &&
+			i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			args[i+1] = r.Mask(args[i+1])
+			i++
+			continue
+		}
+		args[i] = urlPassword.ReplaceAllStringFunc(arg, func(match string) string {
+			parts := urlPassword.FindStringSubmatch(match)
+			return parts[1] + r.Mask(parts[2]) + "@"
+		})
+	}
+	return strings.Join(args, " ")
+}
+
+// Mask returns the redacted form of value.
+func (r *Redactor) Mask(value interface{}) string {
+	if len(r.key) == 0 {
+		return redactedMask
+	}
+	mac := hmac.New(sha256.New, r.key)
+	fmt.Fprintf(mac, "%v", value)
+	return fmt.Sprintf("%s (hmac:%s)", redactedMask, hex.EncodeToString(mac.Sum(nil))[:12])
+}
@@ -0,0 +1,53 @@
+package probe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor(t *testing.T) {
+	r := NewResult("env")
+	r.Set("DB_PASSWORD", "hunter2")
+	r.Set("API_KEY", "abc")
+	r.Set("KEYCLOAK_URL", "https://sso")
+	r.Set("HOME", "/root")
+	r.Set("Header", map[string]interface{}{"Authorization": "Bearer x", "Accept": "*/*"})
+
+	redactor := NewRedactor([]string{"*_URL"}, []string{"KEYCLOAK_*"}, "install-key")
+	redactor.Redact(r)
+
+	assert.True(t, strings.HasPrefix(r.Data["DB_PASSWORD"].(string), redactedMask))
+	assert.Equal(t, redactor.Mask("hunter2"), r.Data["DB_PASSWORD"])
+	assert.NotEqual(t, redactor.Mask("abc"), r.Data["DB_PASSWORD"])
+	assert.Equal(t, redactor.Mask("abc"), r.Data["API_KEY"])
+	assert.Equal(t, "https://sso", r.Data["KEYCLOAK_URL"])
+	assert.Equal(t, "/root", r.Data["HOME"])
+	header := r.Data["Header"].(map[string]interface{})
+	assert.Equal(t, redactor.Mask("Bearer x"), header["Authorization"])
+	assert.Equal(t, "*/*", header["Accept"])
+}
+
+func TestRedactorMask(t *testing.T) {
+	a := NewRedactor(nil, nil, "a")
+	b := NewRedactor(nil, nil, "b")
+	assert.Equal(t, a.Mask("hunter2"), a.Mask("hunter2"))
+	assert.NotEqual(t, a.Mask("hunter2"), b.Mask("hunter2"))
+	assert.Regexp(t, `^\*{6} \(hmac:[0-9a-f]{12}\)$`, a.Mask("hunter2"))
+	assert.Equal(t, redactedMask, NewRedactor(nil, nil, "").Mask("hunter2"))
+}
+
+func TestRedactCommandLine(t *testing.T) {
+	r := NewResult("processes")
+	r.Set("processes", []interface{}{map[string]interface{}{
+		"Name":    "app",
+		"Cmdline": "app --password=hunter2 --api-token s3cret -v TOKEN=abc --db postgres://app:pw@db:5432/app --user bob",
+	}})
+	redactor := NewRedactor(nil, nil, "")
+	redactor.Redact(r)
+	process := r.Data["processes"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "app --password=****** --api-token ****** -v TOKEN=****** --db postgres://app:******@db:5432/app --user bob",
+		process["Cmdline"])
+	assert.Equal(t, "app", process["Name"])
+}
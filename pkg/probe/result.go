@@ -0,0 +1,197 @@
+package probe
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/structs"
+)
+
+// Units used by the builtin probes.
+const (
+	UnitBytes          = "bytes"
+	UnitKiloBytes      = "KB"
+	UnitPercent        = "percent"
+	UnitSeconds        = "seconds"
+	UnitMHz            = "MHz"
+	UnitTimestamp      = "unix-timestamp"
+	UnitBytesPerSecond = "bytes/s"
+	UnitPerSecond      = "1/s"
+)
+
+// Result is the output of a probe. Data values keep their native types, so
+// they may be numbers, booleans, strings, lists or nested maps. Units maps the
+// dotted path of a value (list indexes left out, e.g. "cpus.Mhz") to its unit.
+// Values which only ever grow, such as byte totals, are marked as counters so
+// they can be exported as such.
+type Result struct {
+	Name     string                 `json:"name"`
+	Status   string                 `json:"status"`
+	Summary  string                 `json:"summary"`
+	Error    string                 `json:"error,omitempty" yaml:"error,omitempty"`
+	Duration string                 `json:"duration"`
+	Data     map[string]interface{} `json:"data"`
+	Units    map[string]string      `json:"units,omitempty" yaml:"units,omitempty"`
+	counters map[string]bool
+	// err is the error of a failed probe, kept so its type survives.
+	err error
+}
+
+func NewResult(name string) *Result {
+	return &Result{Name: name, Status: StatusOK, Data: map[string]interface{}{}}
+}
+
+// NewErrorResult returns a result recording that probe name failed with err.
+func NewErrorResult(name string, err error) *Result {
+	result := NewResult(name)
+	result.Status = StatusError
+	result.Error = err.Error()
+	result.err = err
+	return result
+}
+
+// Set stores value under key.
+func (r *Result) Set(key string, value interface{}) {
+	r.Data[key] = value
+}
+
+// SetUnit stores value under key and records its unit.
+func (r *Result) SetUnit(key string, value interface{}, unit string) {
+	r.Data[key] = value
+	r.AddUnit(key, unit)
+}
+
+// AddUnit records the unit of the value at the dotted path.
+func (r *Result) AddUnit(path string, unit string) {
+	if r.Units == nil {
+		r.Units = map[string]string{}
+	}
+	r.Units[path] = unit
+}
+
+// Unit returns the unit of the value at the dotted path, list indexes
+// included or not.
+func (r *Result) Unit(path string) string {
+	if r.Units == nil {
+		return ""
+	}
+	if unit, ok := r.Units[path]; ok {
+		return unit
+	}
+	return r.Units[UnitPath(path)]
+}
+
+// AddCounter marks the value at the dotted path as a monotonic counter.
+func (r *Result) AddCounter(path string) {
+	if r.counters == nil {
+		r.counters = map[string]bool{}
+	}
+	r.counters[path] = true
+}
+
+// IsCounter reports whether the value at the dotted path, list indexes
+// included or not, is a counter.
+func (r *Result) IsCounter(path string) bool {
+	return r.counters[path] || r.counters[UnitPath(path)]
+}
+
+// UnitPath strips list indexes from a dotted path, units and counters are
+// recorded under it for every entry of a list.
+func UnitPath(path string) string {
+	parts := strings.Split(path, ".")
+	kept := parts[:0]
+	for _, part := range parts {
+		if _, err := strconv.Atoi(part); err != nil {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, ".")
+}
+
+// Flatten returns Data as a flat string map, nested keys joined by dots and
+// list elements by their index, as results looked before typed values.
+func (r *Result) Flatten() map[string]string {
+	out := map[string]string{}
+	for k, v := range r.Data {
+		flatten(k, reflect.ValueOf(v), out)
+	}
+	return out
+}
+
+// Flat returns a copy of r with Data flattened, see Flatten.
+func (r *Result) Flat() *Result {
+	flat := *r
+	flat.Data = map[string]interface{}{}
+	for k, v := range r.Flatten() {
+		flat.Data[k] = v
+	}
+	return &flat
+}
+
+func flatten(prefix string, v reflect.Value, out map[string]string) {
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) {
+		if v.IsNil() {
+			out[prefix] = ""
+			return
+		}
+		v = v.Elem()
+	}
+	switch {
+	case !v.IsValid():
+		out[prefix] = ""
+	case isList(v):
+		for i := 0; i < v.Len(); i++ {
+			flatten(prefix+"."+strconv.Itoa(i), v.Index(i), out)
+		}
+	case v.Kind() == reflect.Map:
+		for _, key := range v.MapKeys() {
+			flatten(prefix+"."+fmt.Sprintf("%v", key.Interface()), v.MapIndex(key), out)
+		}
+	default:
+		out[prefix] = fmt.Sprintf("%v", v.Interface())
+	}
+}
+
+func isList(v reflect.Value) bool {
+	return (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8
+}
+
+// Kind classifies a data value as "map", "list" or "scalar".
+func Kind(value interface{}) string {
+	v := reflect.ValueOf(value)
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch {
+	case !v.IsValid():
+		return "scalar"
+	case isList(v):
+		return "list"
+	case v.Kind() == reflect.Map:
+		return "map"
+	}
+	return "scalar"
+}
+
+// SortedKeys returns the keys of a map value in order, and nil for anything
+// else.
+func SortedKeys(value interface{}) []string {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Map {
+		return nil
+	}
+	keys := make([]string, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		keys = append(keys, fmt.Sprintf("%v", key.Interface()))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// StructData converts a struct into a data map keeping field types.
+func StructData(s interface{}) map[string]interface{} {
+	return structs.Map(s)
+}
@@ -0,0 +1,29 @@
+package probe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultFlatten(t *testing.T) {
+	r := NewResult("test")
+	r.SetUnit("total", uint64(1024), UnitBytes)
+	r.Set("ok", true)
+	r.Set("cpus", []interface{}{
+		map[string]interface{}{"Mhz": 2400.5, "Flags": []string{"fpu", "vme"}},
+	})
+	r.AddUnit("cpus.Mhz", UnitMHz)
+
+	assert.Equal(t, map[string]string{
+		"total":          "1024",
+		"ok":             "true",
+		"cpus.0.Mhz":     "2400.5",
+		"cpus.0.Flags.0": "fpu",
+		"cpus.0.Flags.1": "vme",
+	}, r.Flatten())
+	assert.Equal(t, UnitBytes, r.Unit("total"))
+	assert.Equal(t, UnitMHz, r.Unit("cpus.0.Mhz"))
+	assert.Equal(t, "", r.Unit("ok"))
+	assert.Equal(t, "true", r.Flat().Data["ok"])
+}
@@ -0,0 +1,128 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+)
+
+// CheckTLSFunc handshakes with the target parameter (host:port), with the
+// optional sni and alpn (comma separated) parameters, and reports the
+// negotiated parameters and the presented chain. The chain is validated
+// against the system trust store, or the PEM certificates of the ca
+// parameter. It is never a path, a request must not make go-probe read files.
+func CheckTLSFunc(ctx context.Context) (*Result, error) {
+	result := NewResult("check-tls")
+	params := Params(ctx)
+	target := params.Get("target")
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, paramErrorf("Invalid target [%s], expect host:port", target)
+	}
+	serverName := params.Get("sni")
+	if serverName == "" {
+		serverName = host
+	}
+	roots, err := loadRoots(params.Get("ca"))
+	if err != nil {
+		return nil, err
+	}
+	result.Set("target", target)
+	result.Set("sni", serverName)
+
+	config := &tls.Config{
+		ServerName: serverName,
+		// The chain is verified below, so a bad one is reported instead of
+		// failing the handshake.
+		InsecureSkipVerify: true,
+	}
+	if alpn := params.Get("alpn"); alpn != "" {
+		config.NextProtos = strings.Split(alpn, ",")
+	}
+	start := time.Now()
+	dialer := &tls.Dialer{Config: config}
+	rawConn, err := dialer.DialContext(ctx, "tcp", target)
+	result.Set("HandshakeTime", time.Since(start).String())
+	if err != nil {
+		return checkFailed(result, start, err), nil
+	}
+	conn := rawConn.(*tls.Conn)
+	defer conn.Close()
+	state := conn.ConnectionState()
+	result.Set("Version", tlsVersionName(state.Version))
+	result.Set("CipherSuite", tls.CipherSuiteName(state.CipherSuite))
+	result.Set("ALPN", state.NegotiatedProtocol)
+	result.Set("OCSPStapled", len(state.OCSPResponse) > 0)
+
+	chain := make([]interface{}, 0, len(state.PeerCertificates))
+	for _, cert := range state.PeerCertificates {
+		chain = append(chain, certificateData(cert))
+	}
+	result.Set("Chain", chain)
+	result.AddUnit("Chain.DaysLeft", "days")
+
+	if len(state.PeerCertificates) == 0 {
+		return checkFailed(result, start, fmt.Errorf("No certificate presented")), nil
+	}
+	leaf := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	result.Set("Verified", err == nil)
+	if err != nil {
+		result.Set("VerifyError", err.Error())
+	}
+	summary := fmt.Sprintf("%s %s, %s expires %s (%d days)", result.Data["Version"], result.Data["CipherSuite"],
+		leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339), daysLeft(leaf))
+	if err != nil {
+		summary = "Not verified: " + err.Error() + ", " + summary
+	}
+	return checkSucceeded(result, start, summary), nil
+}
+
+// loadRoots parses inline PEM certificates, empty means the system trust
+// store.
+func loadRoots(pem string) (*x509.CertPool, error) {
+	if pem == "" {
+		return nil, nil
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(pem)) {
+		return nil, paramErrorf("No PEM certificate in the ca parameter")
+	}
+	return roots, nil
+}
+
+func certificateData(cert *x509.Certificate) map[string]interface{} {
+	ips := make([]string, 0, len(cert.IPAddresses))
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	return map[string]interface{}{
+		"Subject":            cert.Subject.String(),
+		"Issuer":             cert.Issuer.String(),
+		"SerialNumber":       cert.SerialNumber.String(),
+		"DNSNames":           cert.DNSNames,
+		"IPAddresses":        ips,
+		"NotBefore":          cert.NotBefore.Format(time.RFC3339),
+		"NotAfter":           cert.NotAfter.Format(time.RFC3339),
+		"DaysLeft":           daysLeft(cert),
+		"IsCA":               cert.IsCA,
+		"SignatureAlgorithm": cert.SignatureAlgorithm.String(),
+	}
+}
+
+func daysLeft(cert *x509.Certificate) int {
+	return int(math.Floor(time.Until(cert.NotAfter).Hours() / 24))
+}
@@ -0,0 +1,321 @@
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/jolestar/go-probe/pkg/probe"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Probe policies, a policy may also be a comma separated list of the
+// principals allowed.
+const (
+	PolicyPublic        = "public"
+	PolicyAuthenticated = "authenticated"
+)
+
+// AuthConfig configures who may run which probe. Without any method
+// configured every probe is public.
+type AuthConfig struct {
+	// Tokens maps the name of a client to its bearer token.
+	Tokens map[string]string `yaml:"tokens"`
+	// Basic maps a user name to the bcrypt hash of its password.
+	Basic map[string]string `yaml:"basic"`
+	// ClientCA is a PEM file of the CAs client certificates must chain to,
+	// the principal is the common name of the certificate.
+	ClientCA string `yaml:"client_ca"`
+	// Default is the policy of the probes missing from Probes, authenticated
+	// when empty.
+	Default string            `yaml:"default"`
+	Probes  map[string]string `yaml:"probes"`
+}
+
+// Authenticator identifies the client of a request. It returns "" when the
+// request carries no credentials it handles, and an error when it carries
+// wrong ones.
+type Authenticator interface {
+	Authenticate(req *http.Request) (principal string, err error)
+}
+
+// Auth authenticates requests with a list of authenticators, the first one
+// recognizing credentials wins, and authorizes them per probe.
+type Auth struct {
+	authenticators []Authenticator
+	challenges     []string
+	defaultPolicy  string
+	policies       map[string]string
+}
+
+// NewAuth returns the Auth configured by config plus the extra
+// authenticators, or nil when there is no authenticator at all.
+func NewAuth(config AuthConfig, extra ...Authenticator) (*Auth, error) {
+	auth := &Auth{defaultPolicy: config.Default, policies: config.Probes}
+	if auth.defaultPolicy == "" {
+		auth.defaultPolicy = PolicyAuthenticated
+	}
+	if len(config.Tokens) > 0 {
+		auth.authenticators = append(auth.authenticators, TokenAuth(config.Tokens))
+		auth.challenges = append(auth.challenges, `Bearer realm="go-probe"`)
+	}
+	if len(config.Basic) > 0 {
+		auth.authenticators = append(auth.authenticators, NewBasicAuth(config.Basic))
+		auth.challenges = append(auth.challenges, `Basic realm="go-probe"`)
+	}
+	if config.ClientCA != "" {
+		clientCert, err := NewClientCertAuth(config.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		auth.authenticators = append(auth.authenticators, clientCert)
+	}
+	auth.authenticators = append(auth.authenticators, extra...)
+	if len(auth.authenticators) == 0 {
+		return nil, nil
+	}
+	return auth, nil
+}
+
+// Authenticate returns the principal of req, "" for an anonymous request.
+func (a *Auth) Authenticate(req *http.Request) (string, error) {
+	if a == nil {
+		return "", nil
+	}
+	for _, authenticator := range a.authenticators {
+		principal, err := authenticator.Authenticate(req)
+		if err != nil || principal != "" {
+			return principal, err
+		}
+	}
+	return "", nil
+}
+
+// Allowed reports whether principal, "" for anonymous, may run probe name.
+func (a *Auth) Allowed(principal string, name string) bool {
+	if a == nil {
+		return true
+	}
+	policy, ok := a.policies[name]
+	if !ok {
+		policy = a.defaultPolicy
+	}
+	switch policy {
+	case PolicyPublic:
+		return true
+	case PolicyAuthenticated:
+		return principal != ""
+	}
+	for _, allowed := range SplitList(policy) {
+		if principal != "" && allowed == principal {
+			return true
+		}
+	}
+	return false
+}
+
+// unauthorized returns the error of an anonymous or wrongly authenticated
+// request, challenging the client for credentials.
+func (a *Auth) unauthorized(message string) *HttpError {
+	err := NewHttpError(http.StatusUnauthorized, message)
+	if len(a.challenges) > 0 {
+		err.Header = http.Header{"Www-Authenticate": a.challenges}
+	}
+	return err
+}
+
+// TokenAuth authenticates bearer tokens, it maps the name of each client to
+// its token.
+type TokenAuth map[string]string
+
+func (t TokenAuth) Authenticate(req *http.Request) (string, error) {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", nil
+	}
+	token := []byte(strings.TrimSpace(header[len("Bearer "):]))
+	for name, expected := range t {
+		if subtle.ConstantTimeCompare(token, []byte(expected)) == 1 {
+			return name, nil
+		}
+	}
+	return "", errors.New("invalid bearer token")
+}
+
+// errInvalidCredentials is all a client learns about wrong basic
+// credentials, so it cannot tell unknown users from wrong passwords.
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// BasicAuth authenticates HTTP basic credentials against bcrypt hashes. As
+// bcrypt is slow on purpose, the last password verified for each user is
+// remembered by its sha256. An unknown user is checked against a dummy hash
+// of the same cost, so it takes as long as a wrong password.
+type BasicAuth struct {
+	hashes    map[string]string
+	lock      sync.Mutex
+	verified  map[string][sha256.Size]byte
+	dummyOnce sync.Once
+	dummy     []byte
+}
+
+func NewBasicAuth(hashes map[string]string) *BasicAuth {
+	return &BasicAuth{hashes: hashes, verified: map[string][sha256.Size]byte{}}
+}
+
+// dummyHash returns a hash of the highest cost among the configured ones,
+// generated on first use.
+func (b *BasicAuth) dummyHash() []byte {
+	b.dummyOnce.Do(func() {
+		cost := bcrypt.DefaultCost
+		for _, hash := range b.hashes {
+			if c, err := bcrypt.Cost([]byte(hash)); err == nil && c > cost {
+				cost = c
+			}
+		}
+		b.dummy, _ = bcrypt.GenerateFromPassword([]byte("go-probe"), cost)
+	})
+	return b.dummy
+}
+
+func (b *BasicAuth) Authenticate(req *http.Request) (string, error) {
+	user, password, ok := req.BasicAuth()
+	if !ok {
+		return "", nil
+	}
+	hash, ok := b.hashes[user]
+	if !ok {
+		bcrypt.CompareHashAndPassword(b.dummyHash(), []byte(password))
+		log.Printf("Basic auth: unknown user [%s]\n", user)
+		return "", errInvalidCredentials
+	}
+	sum := sha256.Sum256([]byte(password))
+	b.lock.Lock()
+	verified, ok := b.verified[user]
+	b.lock.Unlock()
+	if ok && subtle.ConstantTimeCompare(sum[:], verified[:]) == 1 {
+		return user, nil
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		log.Printf("Basic auth: invalid password for user [%s]\n", user)
+		return "", errInvalidCredentials
+	}
+	b.lock.Lock()
+	b.verified[user] = sum
+	b.lock.Unlock()
+	return user, nil
+}
+
+// ClientCertAuth authenticates TLS client certificates which chain to its
+// CAs, whatever the TLS config of the listener verified.
+type ClientCertAuth struct {
+	roots *x509.CertPool
+}
+
+func NewClientCertAuth(caFile string) (*ClientCertAuth, error) {
+	b, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificate found in [%s]", caFile)
+	}
+	return &ClientCertAuth{roots: roots}, nil
+}
+
+func (c *ClientCertAuth) Authenticate(req *http.Request) (string, error) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return "", nil
+	}
+	certs := req.TLS.PeerCertificates
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         c.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return "", fmt.Errorf("invalid client certificate: %s", err.Error())
+	}
+	if certs[0].Subject.CommonName == "" {
+		return "", errors.New("client certificate without common name")
+	}
+	return certs[0].Subject.CommonName, nil
+}
+
+// validate checks the auth section of the config, known holds the names of
+// the probes.
+func (c AuthConfig) validate(known map[string]bool) []string {
+	var errs []string
+	if _, err := NewAuth(c); err != nil {
+		errs = append(errs, fmt.Sprintf("auth.client_ca: %s", err.Error()))
+	}
+	for _, user := range sortedKeys(c.Basic) {
+		if _, err := bcrypt.Cost([]byte(c.Basic[user])); err != nil {
+			errs = append(errs, fmt.Sprintf("auth.basic.%s: not a bcrypt hash", user))
+		}
+	}
+	for _, name := range sortedKeys(c.Probes) {
+		if !known[name] {
+			errs = append(errs, fmt.Sprintf("auth.probes: no such probe [%s]", name))
+		}
+		if len(SplitList(c.Probes[name])) == 0 {
+			errs = append(errs, fmt.Sprintf("auth.probes.%s: empty policy", name))
+		}
+	}
+	if (len(c.Probes) > 0 || c.Default != "") && len(c.Tokens) == 0 && len(c.Basic) == 0 && c.ClientCA == "" {
+		errs = append(errs, "auth: policies without any authentication method")
+	}
+	return errs
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type userKey struct{}
+
+// withUser returns a copy of ctx carrying the authenticated principal.
+func withUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+func userOf(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// authorize checks that the user of ctx may run probe name, an empty name
+// standing for all probes, in which case the ones denied are left out.
+func (f *Frame) authorize(ctx context.Context, name string) (context.Context, *HttpError) {
+	auth := f.currentAuth()
+	user := userOf(ctx)
+	if name == "" {
+		return probe.WithFilter(ctx, func(name string) bool {
+			return auth.Allowed(user, name)
+		}), nil
+	}
+	if auth.Allowed(user, name) {
+		return ctx, nil
+	}
+	if user == "" {
+		return ctx, auth.unauthorized(fmt.Sprintf("Authentication required for probe [%s]", name))
+	}
+	return ctx, NewHttpError(http.StatusForbidden, fmt.Sprintf("[%s] may not run probe [%s]", user, name))
+}
@@ -0,0 +1,94 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jolestar/go-probe/pkg/probe"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	f := newTestFrame(t, func(config *Config) {
+		config.Auth = AuthConfig{
+			Tokens: map[string]string{"ci": "t0ken"},
+			Basic:  map[string]string{"alice": string(hash)},
+			Probes: map[string]string{"status": PolicyPublic, "load-avg": "alice"},
+		}
+	})
+
+	// serve requests path with a bearer token when user is empty, with
+	// basic auth otherwise, or anonymously when password is empty too.
+	serve := func(path string, user string, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path+"?format=json", nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		} else if password != "" {
+			req.Header.Set("Authorization", "Bearer "+password)
+		}
+		w := httptest.NewRecorder()
+		f.router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, 200, serve("/status", "", "").Code)
+	w := serve("/env", "", "")
+	assert.Equal(t, 401, w.Code)
+	assert.Equal(t, []string{`Bearer realm="go-probe"`, `Basic realm="go-probe"`}, w.Header()["Www-Authenticate"])
+	assert.Equal(t, 401, serve("/status", "", "wrong").Code)
+	assert.Equal(t, 200, serve("/env", "", "t0ken").Code)
+	assert.Equal(t, 403, serve("/load-avg", "", "t0ken").Code)
+	wrongPassword := serve("/load-avg", "alice", "wrong")
+	unknownUser := serve("/load-avg", "mallory", "wrong")
+	assert.Equal(t, 401, wrongPassword.Code)
+	assert.Equal(t, 401, unknownUser.Code)
+	assert.Contains(t, wrongPassword.Body.String(), `"message":"invalid credentials"`)
+	assert.Contains(t, unknownUser.Body.String(), `"message":"invalid credentials"`)
+	assert.Equal(t, 200, serve("/load-avg", "alice", "secret").Code)
+	assert.Equal(t, 200, serve("/load-avg", "alice", "secret").Code)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	ctx, httpErr := f.authorize(withUser(req.Context(), ""), "")
+	assert.Nil(t, httpErr)
+	r, err := probe.DoProbe(ctx, "")
+	assert.NoError(t, err)
+	if assert.Len(t, r.(*probe.Report).Results, 1) {
+		assert.Equal(t, "status", r.(*probe.Report).Results[0].Name)
+	}
+}
+
+func TestAuthConfigValidate(t *testing.T) {
+	config := AuthConfig{
+		Basic:  map[string]string{"bob": "plain"},
+		Probes: map[string]string{"nosuch": ""},
+	}
+	assert.Equal(t, []string{
+		"auth.basic.bob: not a bcrypt hash",
+		"auth.probes: no such probe [nosuch]",
+		"auth.probes.nosuch: empty policy",
+	}, config.validate(map[string]bool{}))
+	assert.Equal(t, []string{"auth: policies without any authentication method"},
+		AuthConfig{Default: PolicyPublic}.validate(nil))
+}
+
+func TestBasicAuthDummyHash(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost+2)
+	assert.NoError(t, err)
+	basic := NewBasicAuth(map[string]string{"alice": string(hash)})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("mallory", "secret")
+	_, err = basic.Authenticate(req)
+	assert.Equal(t, errInvalidCredentials, err)
+	cost, err := bcrypt.Cost(basic.dummy)
+	assert.NoError(t, err)
+	assert.Equal(t, bcrypt.DefaultCost, cost)
+
+	hash, err = bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.DefaultCost+1)
+	assert.NoError(t, err)
+	basic = NewBasicAuth(map[string]string{"alice": string(hash)})
+	cost, _ = bcrypt.Cost(basic.dummyHash())
+	assert.Equal(t, bcrypt.DefaultCost+1, cost)
+}
@@ -0,0 +1,72 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/jolestar/go-probe/pkg/httputil"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// DefaultCompressMinSize is the smallest body compressed, below it the
+// encoding overhead is not worth it.
+const DefaultCompressMinSize = 1024
+
+// encodings are the offered content encodings, by preference. zstd and
+// brotli need libraries which are not vendored.
+var encodings = []string{"gzip", "deflate"}
+
+// bufferedWriter holds the response body back, so it can be compressed once
+// complete.
+type bufferedWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedWriter(w http.ResponseWriter) *bufferedWriter {
+	return &bufferedWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (b *bufferedWriter) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+// flush writes the body to the underlying writer, compressed with the
+// encoding the request prefers when compression is on and the body reaches
+// the minimum size. It returns the number of bytes sent.
+func (b *bufferedWriter) flush(req *http.Request, output OutputConfig) int {
+	w := b.ResponseWriter
+	body := b.body.Bytes()
+	encoding := ""
+	if output.Compress {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if len(body) >= output.CompressMinSize && w.Header().Get("Content-Encoding") == "" {
+			encoding = httputil.NegotiateContentEncoding(req, encodings)
+		}
+	}
+	var compressed bytes.Buffer
+	var writer io.WriteCloser
+	switch encoding {
+	case "gzip":
+		writer = gzip.NewWriter(&compressed)
+	case "deflate":
+		writer = zlib.NewWriter(&compressed)
+	}
+	if writer != nil {
+		writer.Write(body)
+		writer.Close()
+		body = compressed.Bytes()
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(b.status)
+	w.Write(body)
+	return len(body)
+}
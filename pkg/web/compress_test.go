@@ -0,0 +1,45 @@
+package web
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferedWriterFlush(t *testing.T) {
+	output := OutputConfig{Compress: true, CompressMinSize: 10}
+	body := strings.Repeat("go-probe ", 100)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "deflate;q=0.5, gzip")
+
+	w := httptest.NewRecorder()
+	bw := newBufferedWriter(w)
+	bw.Write([]byte(body))
+	wireSize := bw.flush(req, output)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Equal(t, w.Body.Len(), wireSize)
+	assert.True(t, wireSize < len(body))
+	reader, err := gzip.NewReader(w.Body)
+	if assert.NoError(t, err) {
+		b, _ := ioutil.ReadAll(reader)
+		assert.Equal(t, body, string(b))
+	}
+
+	w = httptest.NewRecorder()
+	bw = newBufferedWriter(w)
+	bw.Write([]byte("short"))
+	assert.Equal(t, 5, bw.flush(req, output))
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+
+	w = httptest.NewRecorder()
+	bw = newBufferedWriter(w)
+	bw.Write([]byte(body))
+	output.Compress = false
+	assert.Equal(t, len(body), bw.flush(req, output))
+	assert.Equal(t, "", w.Header().Get("Vary"))
+}
@@ -0,0 +1,392 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jolestar/go-probe/pkg/probe"
+	yaml "gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of go-probe, read from a YAML file. Every
+// section is optional, see DefaultConfig for the values used when missing.
+type Config struct {
+	Listen string `yaml:"listen"`
+	// Listeners replace Listen when not empty.
+	Listeners []ListenerConfig `yaml:"listeners"`
+	Probes    ProbesConfig     `yaml:"probes"`
+	Redaction RedactionConfig  `yaml:"redaction"`
+	Output    OutputConfig     `yaml:"output"`
+	Auth      AuthConfig       `yaml:"auth"`
+	TLS       TLSConfig        `yaml:"tls"`
+	Server    ServerConfig     `yaml:"server"`
+	Proxy     ProxyConfig      `yaml:"proxy"`
+}
+
+type ProbesConfig struct {
+	// Timeout is the deadline of a single probe, 0 disables it.
+	Timeout     time.Duration `yaml:"timeout"`
+	Concurrency int           `yaml:"concurrency"`
+	// Enabled lists the only probes served, all when empty.
+	Enabled  []string                `yaml:"enabled"`
+	Disabled []string                `yaml:"disabled"`
+	Options  map[string]ProbeOptions `yaml:"options"`
+}
+
+// ProbeOptions are the settings of a single probe.
+type ProbeOptions struct {
+	Timeout time.Duration `yaml:"timeout"`
+	// Params are defaults for the query parameters a request leaves out,
+	// e.g. interval for network-io.
+	Params map[string]string `yaml:"params"`
+}
+
+type RedactionConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Deny    []string `yaml:"deny"`
+	Allow   []string `yaml:"allow"`
+	// FingerprintKey keys the HMAC shown after masked values, the same on
+	// every pod of an install. Masked values carry no fingerprint without it.
+	FingerprintKey string `yaml:"fingerprint_key"`
+}
+
+type OutputConfig struct {
+	// Format is used when the request does not ask for one through Accept.
+	Format string `yaml:"format"`
+	// Pretty indents JSON unless the request sets the pretty parameter.
+	Pretty bool `yaml:"pretty"`
+	// Compress enables gzip or deflate for bodies of CompressMinSize bytes
+	// or more, as the Accept-Encoding of the request allows.
+	Compress        bool `yaml:"compress"`
+	CompressMinSize int  `yaml:"compress_min_size"`
+}
+
+// formatOffers maps the output formats to the content type negotiated for them.
+var formatOffers = map[string]string{
+	"html":     ContentTypeHtml,
+	"json":     ContentTypeJSON,
+	"yaml":     ContentTypeYAML,
+	"text":     ContentTypeText,
+	"markdown": ContentTypeMarkdown,
+	"csv":      ContentTypeCSV,
+	"ndjson":   ContentTypeNDJSON,
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Listen: ":80",
+		Probes: ProbesConfig{
+			Timeout:     probe.DefaultTimeout,
+			Concurrency: probe.DefaultConcurrency,
+		},
+		Redaction: RedactionConfig{Enabled: true},
+		Output:    OutputConfig{Format: "text", Compress: true, CompressMinSize: DefaultCompressMinSize},
+		Server:    DefaultServerConfig(),
+	}
+}
+
+// LoadConfig reads the config file at path on top of DefaultConfig, an empty
+// path skips the file, then applies the GOPROBE_* environment variables.
+func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := parseConfig(data, config); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+	}
+	if err := config.applyEnv(os.Getenv); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func parseConfig(data []byte, config *Config) error {
+	var raw map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if keys := unknownKeys(raw, reflect.TypeOf(*config), ""); len(keys) > 0 {
+		return fmt.Errorf("unknown keys: %s", strings.Join(keys, ", "))
+	}
+	return yaml.Unmarshal(data, config)
+}
+
+// unknownKeys returns the keys of raw which have no matching yaml field in
+// the struct type t, so a typo is reported instead of silently ignored.
+func unknownKeys(raw map[interface{}]interface{}, t reflect.Type, path string) []string {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fields[strings.Split(field.Tag.Get("yaml"), ",")[0]] = field.Type
+	}
+	var keys []string
+	for k, v := range raw {
+		key := fmt.Sprintf("%v", k)
+		fieldType, ok := fields[key]
+		if !ok {
+			keys = append(keys, joinPath(path, key))
+			continue
+		}
+		nested, _ := v.(map[interface{}]interface{})
+		switch {
+		case fieldType.Kind() == reflect.Struct:
+			keys = append(keys, unknownKeys(nested, fieldType, joinPath(path, key))...)
+		case fieldType.Kind() == reflect.Map && fieldType.Elem().Kind() == reflect.Struct:
+			for name, options := range nested {
+				options, _ := options.(map[interface{}]interface{})
+				keys = append(keys, unknownKeys(options, fieldType.Elem(), joinPath(path, fmt.Sprintf("%s.%v", key, name)))...)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// applyEnv overrides the config with the GOPROBE_* variables which are set,
+// lists are comma separated.
+func (c *Config) applyEnv(getenv func(string) string) error {
+	var errs []string
+	env := func(name string, apply func(string) error) {
+		if value := getenv(name); value != "" {
+			if err := apply(value); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+			}
+		}
+	}
+	env("GOPROBE_LISTEN", func(v string) error {
+		c.Listen = v
+		return nil
+	})
+	env("GOPROBE_PROBE_TIMEOUT", func(v string) (err error) {
+		c.Probes.Timeout, err = time.ParseDuration(v)
+		return
+	})
+	env("GOPROBE_PROBE_CONCURRENCY", func(v string) (err error) {
+		c.Probes.Concurrency, err = strconv.Atoi(v)
+		return
+	})
+	env("GOPROBE_PROBES_ENABLED", func(v string) error {
+		c.Probes.Enabled = SplitList(v)
+		return nil
+	})
+	env("GOPROBE_PROBES_DISABLED", func(v string) error {
+		c.Probes.Disabled = SplitList(v)
+		return nil
+	})
+	env("GOPROBE_REDACT", func(v string) (err error) {
+		c.Redaction.Enabled, err = strconv.ParseBool(v)
+		return
+	})
+	env("GOPROBE_REDACT_DENY", func(v string) error {
+		c.Redaction.Deny = SplitList(v)
+		return nil
+	})
+	env("GOPROBE_REDACT_ALLOW", func(v string) error {
+		c.Redaction.Allow = SplitList(v)
+		return nil
+	})
+	env("GOPROBE_REDACT_FINGERPRINT_KEY", func(v string) error {
+		c.Redaction.FingerprintKey = v
+		return nil
+	})
+	env("GOPROBE_OUTPUT_FORMAT", func(v string) error {
+		c.Output.Format = v
+		return nil
+	})
+	env("GOPROBE_OUTPUT_PRETTY", func(v string) (err error) {
+		c.Output.Pretty, err = strconv.ParseBool(v)
+		return
+	})
+	env("GOPROBE_OUTPUT_COMPRESS", func(v string) (err error) {
+		c.Output.Compress, err = strconv.ParseBool(v)
+		return
+	})
+	env("GOPROBE_AUTH_TOKENS", func(v string) error {
+		c.Auth.Tokens = map[string]string{}
+		for i, item := range SplitList(v) {
+			kv := strings.SplitN(item, "=", 2)
+			if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+				return fmt.Errorf("item %d is not name=token", i+1)
+			}
+			c.Auth.Tokens[kv[0]] = kv[1]
+		}
+		return nil
+	})
+	env("GOPROBE_OUTPUT_COMPRESS_MIN_SIZE", func(v string) (err error) {
+		c.Output.CompressMinSize, err = strconv.Atoi(v)
+		return
+	})
+	env("GOPROBE_WRITE_TIMEOUT", func(v string) (err error) {
+		c.Server.WriteTimeout, err = time.ParseDuration(v)
+		return
+	})
+	env("GOPROBE_SHUTDOWN_DELAY", func(v string) (err error) {
+		c.Server.ShutdownDelay, err = time.ParseDuration(v)
+		return
+	})
+	env("GOPROBE_SHUTDOWN_GRACE", func(v string) (err error) {
+		c.Server.ShutdownGrace, err = time.ParseDuration(v)
+		return
+	})
+	env("GOPROBE_TRUSTED_PROXIES", func(v string) error {
+		c.Proxy.Trusted = SplitList(v)
+		return nil
+	})
+	env("GOPROBE_TLS_CERT", func(v string) error {
+		c.TLS.Cert = v
+		return nil
+	})
+	env("GOPROBE_TLS_KEY", func(v string) error {
+		c.TLS.Key = v
+		return nil
+	})
+	env("GOPROBE_TLS_SELF_SIGNED", func(v string) (err error) {
+		c.TLS.SelfSigned, err = strconv.ParseBool(v)
+		return
+	})
+	env("GOPROBE_AUTH_CLIENT_CA", func(v string) error {
+		c.Auth.ClientCA = v
+		return nil
+	})
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Validate checks the whole config and reports every problem found at once.
+func (c *Config) Validate() error {
+	var errs []string
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil && len(c.Listeners) == 0 {
+		errs = append(errs, fmt.Sprintf("listen: %s", err.Error()))
+	}
+	if c.Probes.Timeout < 0 {
+		errs = append(errs, "probes.timeout: must not be negative")
+	}
+	if c.Probes.Concurrency < 1 {
+		errs = append(errs, "probes.concurrency: must be at least 1")
+	}
+	known := map[string]bool{}
+	for _, name := range probe.Names() {
+		known[name] = true
+	}
+	for _, name := range c.Probes.Enabled {
+		if !known[name] {
+			errs = append(errs, fmt.Sprintf("probes.enabled: no such probe [%s]", name))
+		}
+	}
+	for _, name := range c.Probes.Disabled {
+		if !known[name] {
+			errs = append(errs, fmt.Sprintf("probes.disabled: no such probe [%s]", name))
+		}
+	}
+	for _, name := range sortedOptionNames(c.Probes.Options) {
+		if !known[name] {
+			errs = append(errs, fmt.Sprintf("probes.options: no such probe [%s]", name))
+		}
+		if c.Probes.Options[name].Timeout < 0 {
+			errs = append(errs, fmt.Sprintf("probes.options.%s.timeout: must not be negative", name))
+		}
+	}
+	for _, pattern := range append(append([]string{}, c.Redaction.Deny...), c.Redaction.Allow...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Sprintf("redaction: bad pattern [%s]", pattern))
+		}
+	}
+	if _, ok := formatOffers[c.Output.Format]; !ok {
+		errs = append(errs, fmt.Sprintf("output.format: unknown format [%s]", c.Output.Format))
+	}
+	if c.Output.CompressMinSize < 0 {
+		errs = append(errs, "output.compress_min_size: must not be negative")
+	}
+	errs = append(errs, c.Auth.validate(known)...)
+	errs = append(errs, c.TLS.validate()...)
+	errs = append(errs, c.Server.validate(c.Probes)...)
+	if _, err := parseTrusted(c.Proxy.Trusted); err != nil {
+		errs = append(errs, fmt.Sprintf("proxy.trusted: %s", err.Error()))
+	}
+	addresses := map[string]bool{}
+	for i, l := range c.Listeners {
+		errs = append(errs, l.validate(i, c.TLS.Enabled(), known)...)
+		if l.ProxyProtocol && len(c.Proxy.Trusted) == 0 {
+			errs = append(errs, fmt.Sprintf("listeners.%d.proxy_protocol: needs the proxies in proxy.trusted", i))
+		}
+		key := l.Address
+		if l.Network == "unix" {
+			key = "unix:" + key
+		}
+		if addresses[key] {
+			errs = append(errs, fmt.Sprintf("listeners.%d.address: duplicate [%s]", i, l.Address))
+		}
+		addresses[key] = true
+	}
+	if c.Auth.ClientCA != "" && !c.TLS.Enabled() {
+		errs = append(errs, "auth.client_ca: client certificates need tls")
+	}
+	if c.TLS.ClientAuth != "" && !c.TLS.Enabled() {
+		errs = append(errs, "tls: client_auth needs cert and key or self_signed")
+	}
+	if c.TLS.ClientAuth == "require" && c.Auth.ClientCA == "" {
+		errs = append(errs, "tls.client_auth: require needs auth.client_ca")
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func sortedOptionNames(options map[string]ProbeOptions) []string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// probeConfig converts the probes and redaction sections to the settings of
+// the probe package.
+func (c *Config) probeConfig() probe.Config {
+	config := probe.Config{
+		Concurrency: c.Probes.Concurrency,
+		Timeout:     c.Probes.Timeout,
+		Enabled:     c.Probes.Enabled,
+		Disabled:    c.Probes.Disabled,
+		Options:     map[string]probe.Options{},
+	}
+	for name, options := range c.Probes.Options {
+		params := url.Values{}
+		for k, v := range options.Params {
+			params.Set(k, v)
+		}
+		config.Options[name] = probe.Options{Timeout: options.Timeout, Params: params}
+	}
+	if c.Redaction.Enabled {
+		config.Redactor = probe.NewRedactor(c.Redaction.Deny, c.Redaction.Allow, c.Redaction.FingerprintKey)
+	}
+	return config
+}
+
+// SplitList splits a comma separated list, dropping empty items.
+func SplitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
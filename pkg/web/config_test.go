@@ -0,0 +1,64 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfig(t *testing.T) {
+	config := DefaultConfig()
+	err := parseConfig([]byte(`
+listen: ":8080"
+probes:
+  timeout: 2s
+  disabled: [env]
+  options:
+    network-io:
+      timeout: 15s
+      params:
+        interval: "5"
+output:
+  format: json
+`), config)
+	assert.NoError(t, err)
+	assert.Equal(t, ":8080", config.Listen)
+	assert.Equal(t, 2*time.Second, config.Probes.Timeout)
+	assert.Equal(t, 4, config.Probes.Concurrency)
+	assert.Equal(t, []string{"env"}, config.Probes.Disabled)
+	assert.Equal(t, 15*time.Second, config.Probes.Options["network-io"].Timeout)
+	assert.Equal(t, "5", config.Probes.Options["network-io"].Params["interval"])
+	assert.True(t, config.Redaction.Enabled)
+	assert.NoError(t, config.Validate())
+
+	err = parseConfig([]byte("listen: \":80\"\nprobes:\n  timout: 1s\n  options:\n    env:\n      param: {}\n"), DefaultConfig())
+	assert.EqualError(t, err, "unknown keys: probes.options.env.param, probes.timout")
+}
+
+func TestConfigEnvAndValidate(t *testing.T) {
+	config := DefaultConfig()
+	env := map[string]string{
+		"GOPROBE_LISTEN":            "nohost",
+		"GOPROBE_PROBE_CONCURRENCY": "0",
+		"GOPROBE_PROBES_DISABLED":   "env, nosuch",
+		"GOPROBE_OUTPUT_FORMAT":     "xml",
+	}
+	assert.NoError(t, config.applyEnv(func(name string) string { return env[name] }))
+	assert.Equal(t, []string{"env", "nosuch"}, config.Probes.Disabled)
+	err := config.Validate()
+	if assert.Error(t, err) {
+		for _, s := range []string{"listen:", "probes.concurrency", "[nosuch]", "output.format"} {
+			assert.Contains(t, err.Error(), s)
+		}
+	}
+
+	// listen is unused, and not validated, once listeners are set.
+	config = DefaultConfig()
+	config.Listen = ""
+	config.Listeners = []ListenerConfig{{Network: "unix", Address: "/run/go-probe.sock"}}
+	assert.NoError(t, config.Validate())
+
+	env = map[string]string{"GOPROBE_PROBE_TIMEOUT": "soon"}
+	assert.Error(t, config.applyEnv(func(name string) string { return env[name] }))
+}
@@ -3,6 +3,8 @@ package web
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
@@ -11,35 +13,162 @@ import (
 	yaml "gopkg.in/yaml.v2"
 	"html/template"
 	"log"
-	"net"
 	"net/http"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
 	"github.com/jolestar/go-probe/pkg/httputil"
 	"os"
 )
 
 const (
-	ContentHtml     = 1
-	ContentTypeHtml = "text/html"
-	ContentJSON     = 2
-	ContentTypeJSON = "application/json"
-	ContentYAML     = 3
-	ContentTypeYAML = "application/yaml"
+	ContentHtml         = 1
+	ContentTypeHtml     = "text/html"
+	ContentJSON         = 2
+	ContentTypeJSON     = "application/json"
+	ContentYAML         = 3
+	ContentTypeYAML     = "application/yaml"
+	ContentText         = 4
+	ContentTypeText     = "text/plain"
+	ContentMarkdown     = 5
+	ContentTypeMarkdown = "text/markdown"
+	ContentCSV          = 6
+	ContentTypeCSV      = "text/csv"
+	ContentNDJSON       = 7
+	ContentTypeNDJSON   = "application/x-ndjson"
 )
 
-type Config struct {
-	Listen string `yaml:"listen"`
-}
-
 type Frame struct {
-	router       *mux.Router
-	config       *Config
-	requestIDGen atomic.AtomicLong
+	router         *mux.Router
+	config         *Config
+	auth           *Auth
+	authenticators []Authenticator
+	trusted        trustedProxies
+	tlsConfig      *tls.Config
+	selfSigned     *tls.Certificate
+	configLock     sync.RWMutex
+	requestIDGen   atomic.AtomicLong
+	metrics        *metrics
+	servers        []*http.Server
+	serversLock    sync.Mutex
+	draining       atomic.AtomicInteger
 }
 
 func New(config *Config) (*Frame, error) {
-	return &Frame{router: mux.NewRouter(), config: config}, nil
+	f := &Frame{router: mux.NewRouter(), metrics: newMetrics()}
+	if err := f.Reload(config); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload validates config and applies it to the probes and to the requests
+// which follow, the ones in flight finish with the old settings. Changed
+// listeners, TLS turned on or off included, and server timeouts only take
+// effect on restart: the TLS settings are kept while TLS is turned off.
+func (f *Frame) Reload(config *Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	f.configLock.Lock()
+	defer f.configLock.Unlock()
+	auth, err := NewAuth(config.Auth, f.authenticators...)
+	if err != nil {
+		return err
+	}
+	trusted, err := parseTrusted(config.Proxy.Trusted)
+	if err != nil {
+		return err
+	}
+	var tlsConfig *tls.Config
+	if config.TLS.Enabled() {
+		tlsConfig, err = newTLSConfig(config.TLS, config.Auth.ClientCA, f.selfSignedCert)
+		if err != nil {
+			return fmt.Errorf("tls: %s", err.Error())
+		}
+	}
+	if f.config != nil && !reflect.DeepEqual(f.config.listeners(), config.listeners()) {
+		log.Printf("Listeners changed to %v, restart to apply them\n", config.listeners())
+	}
+	if tlsConfig == nil && f.tlsConfig != nil {
+		log.Printf("TLS turned off, the listeners keep serving HTTPS until restart\n")
+		tlsConfig = f.tlsConfig
+	}
+	if f.config != nil && f.config.Server != config.Server {
+		log.Printf("Server settings changed, the timeouts apply on restart\n")
+	}
+	probe.Configure(config.probeConfig())
+	f.config = config
+	f.auth = auth
+	f.trusted = trusted
+	f.tlsConfig = tlsConfig
+	return nil
+}
+
+// Use adds an authenticator tried after the configured ones, it is kept
+// across reloads.
+func (f *Frame) Use(authenticator Authenticator) error {
+	f.configLock.Lock()
+	defer f.configLock.Unlock()
+	auth, err := NewAuth(f.config.Auth, append(f.authenticators, authenticator)...)
+	if err != nil {
+		return err
+	}
+	f.authenticators = append(f.authenticators, authenticator)
+	f.auth = auth
+	return nil
+}
+
+func (f *Frame) currentAuth() *Auth {
+	f.configLock.RLock()
+	defer f.configLock.RUnlock()
+	return f.auth
+}
+
+// selfSignedCert generates the self-signed certificate on first use and keeps
+// it, so clients which accepted it keep working across reloads.
+func (f *Frame) selfSignedCert() (*tls.Certificate, error) {
+	if f.selfSigned == nil {
+		cert, err := generateSelfSigned()
+		if err != nil {
+			return nil, err
+		}
+		f.selfSigned = cert
+	}
+	return f.selfSigned, nil
+}
+
+// currentTLSConfig is the GetConfigForClient of the listener, so handshakes
+// pick up the TLS settings of a reload.
+func (f *Frame) currentTLSConfig(*tls.ClientHelloInfo) (*tls.Config, error) {
+	f.configLock.RLock()
+	defer f.configLock.RUnlock()
+	return f.tlsConfig, nil
+}
+
+func (f *Frame) currentTrusted() trustedProxies {
+	f.configLock.RLock()
+	defer f.configLock.RUnlock()
+	return f.trusted
+}
+
+func (f *Frame) currentConfig() *Config {
+	f.configLock.RLock()
+	defer f.configLock.RUnlock()
+	return f.config
+}
+
+type outputKey struct{}
+
+// outputConfig returns the output defaults the request was started with.
+func outputConfig(req *http.Request) OutputConfig {
+	if output, ok := req.Context().Value(outputKey{}).(OutputConfig); ok {
+		return output
+	}
+	return DefaultConfig().Output
 }
 
 func (f *Frame) Init() {
@@ -49,6 +178,10 @@ func (f *Frame) Init() {
 type HttpError struct {
 	Status  int
 	Message string
+	// Probe is the name of the probe the error is about, if any.
+	Probe string
+	// Header is added to the response, e.g. WWW-Authenticate.
+	Header http.Header
 }
 
 func NewHttpError(status int, Message string) *HttpError {
@@ -66,111 +199,188 @@ func (e HttpError) Error() string {
 func (f *Frame) initRouter() {
 	f.router.HandleFunc("/favicon.ico", http.NotFound)
 
+	f.router.HandleFunc("/process/{pid:[0-9]+}", f.handleWrapper(f.onDemand("process"))).Methods("GET")
+	f.router.HandleFunc("/dns/resolve", f.handleWrapper(f.onDemand("dns-resolve"))).Methods("GET")
+	f.router.HandleFunc("/check/{check}", f.handleWrapper(f.check)).Methods("GET")
+	f.router.HandleFunc("/metrics", f.metricsHandler).Methods("GET")
 	f.router.HandleFunc("/", f.handleWrapper(f.root)).Methods("GET")
 	f.router.HandleFunc("/{probeName:.*}", f.handleWrapper(f.root)).Methods("GET")
 }
 
 func (f *Frame) root(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
-	vars := mux.Vars(req)
-	probeName := vars["probeName"]
-	ctx = context.WithValue(ctx, "request", req)
+	return f.doProbe(ctx, req, mux.Vars(req)["probeName"])
+}
+
+// onDemand handles a route of an on-demand probe, whose path variables are
+// passed to it as parameters.
+func (f *Frame) onDemand(probeName string) handleFunc {
+	return func(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+		return f.doProbe(ctx, req, probeName)
+	}
+}
+
+// check handles /check/{check}, running the on-demand probe check-{check}.
+func (f *Frame) check(ctx context.Context, req *http.Request) (interface{}, *HttpError) {
+	return f.doProbe(ctx, req, "check-"+mux.Vars(req)["check"])
+}
+
+func (f *Frame) doProbe(ctx context.Context, req *http.Request, probeName string) (interface{}, *HttpError) {
+	params := req.URL.Query()
+	for k, v := range mux.Vars(req) {
+		if k != "probeName" && k != "check" {
+			params.Set(k, v)
+		}
+	}
+	ctx, httpErr := f.authorize(ctx, probeName)
+	if httpErr != nil {
+		httpErr.Probe = probeName
+		return nil, httpErr
+	}
+	if probeName == "status" && f.isDraining() {
+		// Readiness fails first, so load balancers stop routing to us.
+		return nil, &HttpError{Status: http.StatusServiceUnavailable, Message: "Shutting down", Probe: probeName}
+	}
+	ctx = probe.WithRequest(ctx, req)
+	ctx = probe.WithClientIP(ctx, f.requestIP(req))
+	ctx = probe.WithParams(ctx, params)
 	r, err := probe.DoProbe(ctx, probeName)
+	f.observe(probeName, r, err)
 	if err != nil {
-		httpErr, ok := err.(*HttpError)
-		if ok {
-			return nil, httpErr
-		} else {
-			return nil, NewServerError(err)
+		var httpErr *HttpError
+		switch e := err.(type) {
+		case *HttpError:
+			httpErr = e
+		case *probe.NoSuchProbeError, *probe.NotFoundError:
+			httpErr = NewHttpError(http.StatusNotFound, err.Error())
+		case *probe.ParamError:
+			httpErr = NewHttpError(http.StatusBadRequest, err.Error())
+		default:
+			httpErr = NewServerError(err)
+		}
+		httpErr.Probe = probeName
+		return nil, httpErr
+	}
+	if boolParam(req, "flat") {
+		switch v := r.(type) {
+		case *probe.Report:
+			return v.Flat(), nil
+		case *probe.Result:
+			return v.Flat(), nil
 		}
 	}
 	return r, nil
 }
 
+// observe records the probe runs of a DoProbe call in the metrics.
+func (f *Frame) observe(probeName string, r interface{}, err error) {
+	switch v := r.(type) {
+	case *probe.Report:
+		for _, result := range v.Results {
+			f.metrics.observeResult(result)
+		}
+	case *probe.Result:
+		f.metrics.observeResult(v)
+	}
+	// Requests for unknown probes or objects and bad parameters are the
+	// client's fault, not probe errors.
+	switch err.(type) {
+	case nil, *probe.NoSuchProbeError, *probe.NotFoundError, *probe.ParamError:
+	default:
+		f.metrics.observeError(probeName)
+	}
+}
+
+func boolParam(req *http.Request, name string) bool {
+	param := req.FormValue(name)
+	return param != "" && param != "false"
+}
+
 type handleFunc func(ctx context.Context, req *http.Request) (interface{}, *HttpError)
 
 func (f *Frame) handleWrapper(handler handleFunc) func(w http.ResponseWriter, req *http.Request) {
 
 	return func(w http.ResponseWriter, req *http.Request) {
 		start := time.Now()
+		req = req.WithContext(context.WithValue(req.Context(), outputKey{}, f.currentConfig().Output))
 		requestID := f.generateRequestID()
-
-		ctx := context.WithValue(req.Context(), "requestID", requestID)
-		cancelCtx, cancelFun := context.WithCancel(ctx)
-		if x, ok := w.(http.CloseNotifier); ok {
-			closeNotify := x.CloseNotify()
-			go func() {
-				select {
-				case <-closeNotify:
-					cancelFun()
-				}
-			}()
+		auth := f.currentAuth()
+		user, authErr := auth.Authenticate(req)
+
+		// The context of req is canceled when the client goes away, which
+		// stops the probes still running.
+		ctx := withUser(probe.WithRequestID(req.Context(), requestID), user)
+		var result interface{}
+		var err *HttpError
+		if format := req.URL.Query().Get("format"); format != "" && formatContents[format] == 0 {
+			err = NewHttpError(http.StatusBadRequest, fmt.Sprintf("Unknown format [%s]", format))
+		} else if authErr != nil {
+			err = auth.unauthorized(authErr.Error())
 		} else {
-			defer cancelFun()
+			result, err = handler(ctx, req)
 		}
-		result, err := handler(cancelCtx, req)
 
-		w.Header().Add("X-RequestID", requestID)
+		bw := newBufferedWriter(w)
+		bw.Header().Add("X-RequestID", requestID)
+		bw.Header().Add("Vary", "Accept")
 		elapsed := time.Since(start)
 		status := 200
-		var len int
 		if err != nil {
 			status = err.Status
-			respondError(w, req, err.Message, status)
+			for k, v := range err.Header {
+				bw.Header()[k] = v
+			}
+			respondError(bw, req, err)
 			f.errorLog(requestID, status, err.Message)
 		} else {
 			if result == nil {
-				respondSuccessDefault(w, req)
+				respondSuccessDefault(bw, req)
 			} else {
-				len = respondSuccess(w, req, result)
+				respondSuccess(bw, req, result)
 			}
 		}
-		f.requestLog(requestID, req, status, elapsed, len)
-	}
-}
-
-func (f *Frame) requestIP(req *http.Request) string {
-	clientIp := req.Header.Get("X-Forwarded-For")
-	if len(clientIp) > 0 {
-		return clientIp
-	}
-	clientIp, _, err := net.SplitHostPort(req.RemoteAddr)
-	if err != nil {
-		log.Fatalf("Get RequestIP error: %s\n", err.Error())
+		reqLog := RequestLog{
+			RequestID:      requestID,
+			User:           user,
+			ResponseStatus: status,
+			ResponseTime:   int64(elapsed / time.Millisecond),
+			ResponseSize:   bw.body.Len(),
+		}
+		if status == http.StatusUnauthorized || status == http.StatusForbidden {
+			reqLog.AuthDenied = err.Message
+		}
+		reqLog.ResponseWireSize = bw.flush(req, outputConfig(req))
+		f.requestLog(req, reqLog)
 	}
-	return clientIp
 }
 
 func (f *Frame) generateRequestID() string {
 	return fmt.Sprintf("REQ-%d", f.requestIDGen.IncrementAndGet())
 }
 
-func (f *Frame) Serve() {
-	log.Printf("Listening on %s \n", f.config.Listen)
-	log.Fatal("%v", http.ListenAndServe(f.config.Listen, f.router))
-}
-
 type RequestLog struct {
-	RequestID string
-	RequestMethod string
-	RequestIP string
-	RequestURI string
+	RequestID            string
+	RequestMethod        string
+	RequestIP            string
+	RequestURI           string
 	RequestContentLength int64
+	// User is the authenticated principal, AuthDenied why access was denied.
+	User           string `json:",omitempty"`
+	AuthDenied     string `json:",omitempty"`
 	ResponseStatus int
-	ResponseTime int64
-	ResponseSize int
+	ResponseTime   int64
+	// ResponseSize is the size of the body, ResponseWireSize the size sent
+	// after compression.
+	ResponseSize     int
+	ResponseWireSize int
 }
 
-func (f *Frame) requestLog(requestID string, req *http.Request, status int, elapsed time.Duration, len int) {
-	reqLog := RequestLog{
-		RequestID: requestID,
-		RequestMethod: req.Method,
-		RequestIP: f.requestIP(req),
-		RequestURI: req.URL.RequestURI(),
-		RequestContentLength: req.ContentLength,
-		ResponseStatus: status,
-		ResponseTime: int64(elapsed/time.Millisecond),
-		ResponseSize: len,
-	}
+// requestLog completes reqLog with the fields of req and logs it.
+func (f *Frame) requestLog(req *http.Request, reqLog RequestLog) {
+	reqLog.RequestMethod = req.Method
+	reqLog.RequestIP = f.requestIP(req)
+	reqLog.RequestURI = req.URL.RequestURI()
+	reqLog.RequestContentLength = req.ContentLength
+	f.metrics.observeRequest(reqLog)
 	b, err := json.Marshal(reqLog)
 	if err != nil {
 		log.Printf("Error to marshal reqLog %+v\n", reqLog)
@@ -183,42 +393,145 @@ func (f *Frame) errorLog(requestID string, status int, msg string) {
 	log.Printf("ERR %s %v %s\n", requestID, status, msg)
 }
 
+var contentOffers = []string{
+	"text/plain",
+	"text/markdown",
+	"text/html",
+	"application/json",
+	"application/yaml",
+	"application/x-yaml",
+	"text/x-yaml",
+	"text/csv",
+	"application/x-ndjson",
+}
+
+// formatContents maps the values of the format parameter to the content
+// they select.
+var formatContents = map[string]int{
+	"html":     ContentHtml,
+	"json":     ContentJSON,
+	"yaml":     ContentYAML,
+	"text":     ContentText,
+	"markdown": ContentMarkdown,
+	"csv":      ContentCSV,
+	"ndjson":   ContentNDJSON,
+}
+
+// contentType returns the content selected by the format parameter, or else
+// negotiates it. The configured output format is offered first so it wins
+// when the request accepts anything.
 func contentType(req *http.Request) int {
-	str := httputil.NegotiateContentType(req, []string{
-		"text/plain",
-		"text/html",
-		"application/json",
-		"application/yaml",
-		"application/x-yaml",
-		"text/x-yaml",
-	}, "text/plain")
-
-	if strings.Contains(str, "json") {
+	if content, ok := formatContents[req.URL.Query().Get("format")]; ok {
+		return content
+	}
+	defaultOffer := formatOffers[outputConfig(req).Format]
+	offers := []string{defaultOffer}
+	for _, offer := range contentOffers {
+		if offer != defaultOffer {
+			offers = append(offers, offer)
+		}
+	}
+	str := httputil.NegotiateContentType(req, offers, defaultOffer)
+
+	if str == ContentTypeNDJSON {
+		return ContentNDJSON
+	} else if str == ContentTypeCSV {
+		return ContentCSV
+	} else if strings.Contains(str, "json") {
 		return ContentJSON
 	} else if strings.Contains(str, "yaml") {
 		return ContentYAML
+	} else if str == ContentTypeText {
+		return ContentText
+	} else if str == ContentTypeMarkdown {
+		return ContentMarkdown
 	} else {
 		return ContentHtml
 	}
 }
 
-func respondError(w http.ResponseWriter, req *http.Request, msg string, statusCode int) {
-	obj := make(map[string]interface{})
-	obj["message"] = msg
-	obj["type"] = "ERROR"
-	obj["code"] = statusCode
+// ErrorEnvelope is the body of every error response, whatever the format.
+type ErrorEnvelope struct {
+	Code      int    `json:"code" yaml:"code"`
+	Type      string `json:"type" yaml:"type"`
+	Message   string `json:"message" yaml:"message"`
+	RequestID string `json:"request_id,omitempty" yaml:"request_id,omitempty"`
+	Probe     string `json:"probe,omitempty" yaml:"probe,omitempty"`
+}
 
-	switch contentType(req) {
+// respondError renders httpErr as an ErrorEnvelope in the negotiated format.
+// The request ID is taken from the X-RequestID header already set on w.
+func respondError(w http.ResponseWriter, req *http.Request, httpErr *HttpError) {
+	envelope := ErrorEnvelope{
+		Code:      httpErr.Status,
+		Type:      "ERROR",
+		Message:   httpErr.Message,
+		RequestID: w.Header().Get("X-RequestID"),
+		Probe:     httpErr.Probe,
+	}
+	fields := [][2]string{
+		{"code", strconv.Itoa(envelope.Code)},
+		{"type", envelope.Type},
+		{"message", envelope.Message},
+		{"request_id", envelope.RequestID},
+		{"probe", envelope.Probe},
+	}
+	var buffer bytes.Buffer
+	var mediaType string
+	switch content := contentType(req); content {
 	case ContentHtml:
-		http.Error(w, msg, statusCode)
-	case ContentJSON:
-		bytes, err := json.Marshal(obj)
-		if err == nil {
-			http.Error(w, string(bytes), statusCode)
-		} else {
-			http.Error(w, "{\"type\": \"error\", \"message\": \"JSON marshal error\"}", http.StatusInternalServerError)
+		mediaType = ContentTypeHtml
+		fmt.Fprintf(&buffer, "<h2>Error %d</h2><table>", envelope.Code)
+		for _, field := range fields {
+			if field[1] != "" {
+				fmt.Fprintf(&buffer, "<tr><td>%s</td><td>%s</td></tr>", field[0], template.HTMLEscapeString(field[1]))
+			}
+		}
+		buffer.WriteString("</table>")
+	case ContentText:
+		mediaType = ContentTypeText + "; charset=utf-8"
+		tw := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', 0)
+		for _, field := range fields {
+			if field[1] != "" {
+				fmt.Fprintf(tw, "%s\t%s\n", field[0], oneLine(field[1]))
+			}
 		}
+		tw.Flush()
+	case ContentMarkdown:
+		mediaType = ContentTypeMarkdown + "; charset=utf-8"
+		fmt.Fprintf(&buffer, "**Error %d:** %s\n\n| Key | Value |\n| --- | --- |\n", envelope.Code, markdownEscaper.Replace(envelope.Message))
+		for _, field := range fields {
+			if field[1] != "" {
+				fmt.Fprintf(&buffer, "| %s | %s |\n", field[0], markdownEscaper.Replace(field[1]))
+			}
+		}
+	case ContentCSV:
+		mediaType = ContentTypeCSV + "; charset=utf-8"
+		writer := csv.NewWriter(&buffer)
+		var header, row []string
+		for _, field := range fields {
+			header = append(header, field[0])
+			row = append(row, field[1])
+		}
+		writer.Write(header)
+		writer.Write(row)
+		writer.Flush()
+	case ContentYAML:
+		mediaType = ContentTypeYAML
+		b, _ := yaml.Marshal(envelope)
+		buffer.Write(b)
+	default:
+		mediaType = ContentTypeJSON
+		if content == ContentNDJSON {
+			mediaType = ContentTypeNDJSON
+		}
+		json.NewEncoder(&buffer).Encode(envelope)
 	}
+	w.Header().Del("Content-Encoding")
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(envelope.Code)
+	w.Write(buffer.Bytes())
 }
 
 func respondSuccessDefault(w http.ResponseWriter, req *http.Request) {
@@ -228,10 +541,18 @@ func respondSuccessDefault(w http.ResponseWriter, req *http.Request) {
 	switch contentType(req) {
 	case ContentHtml:
 		respondHtml(w, req, "OK")
+	case ContentText:
+		respondText(w, req, "OK")
+	case ContentMarkdown:
+		respondMarkdown(w, req, "OK")
 	case ContentJSON:
 		respondJSON(w, req, obj)
 	case ContentYAML:
 		respondYAML(w, req, obj)
+	case ContentCSV:
+		respondCSV(w, req, obj)
+	case ContentNDJSON:
+		respondNDJSON(w, req, obj)
 	}
 }
 
@@ -239,10 +560,18 @@ func respondSuccess(w http.ResponseWriter, req *http.Request, val interface{}) i
 	switch contentType(req) {
 	case ContentHtml:
 		return respondHtml(w, req, val)
+	case ContentText:
+		return respondText(w, req, val)
+	case ContentMarkdown:
+		return respondMarkdown(w, req, val)
 	case ContentJSON:
 		return respondJSON(w, req, val)
 	case ContentYAML:
 		respondYAML(w, req, val)
+	case ContentCSV:
+		return respondCSV(w, req, val)
+	case ContentNDJSON:
+		return respondNDJSON(w, req, val)
 	}
 	return 0
 }
@@ -254,16 +583,61 @@ var (
 )
 
 func init() {
-	listTemplate, initErr = template.New("listTemplate").Parse(`{{range .}}<a href="{{.Name}}">{{.Name}}</a><br/>{{end}}`)
+	listTemplate, initErr = template.New("listTemplate").Parse(`<h4>Status: {{.Status}}{{if .Failed}} ({{.Failed}} failed){{end}}</h4><table>{{range .Results}}<tr><td><a href="{{.Name}}">{{.Name}}</a></td><td>{{.Status}}</td><td>{{.Duration}}</td><td>{{.Error}}</td></tr>{{end}}</table>`)
 	if initErr != nil {
 		panic(initErr)
 	}
-	resultTemplate, initErr = template.New("resultTemplate").Parse(`<h2>{{.Name}}</h2><h4>{{.Summary}}</h4><table>{{range $k,$v := .Data}}<tr><td>{{$k}}</td><td>{{$v}}</td></tr>{{end}}</table>`)
+	resultTemplate, initErr = template.New("resultTemplate").Funcs(template.FuncMap{"data": htmlData}).Parse(`<h2>{{.Name}}</h2><h4>{{.Summary}}</h4>{{if .Error}}<p>Error: {{.Error}}</p>{{end}}{{data .}}`)
 	if initErr != nil {
 		panic(initErr)
 	}
 }
 
+// htmlData renders the data of a result as a table, nested maps and lists as
+// nested tables.
+func htmlData(result *probe.Result) template.HTML {
+	var buffer bytes.Buffer
+	writeHtmlValue(&buffer, result, "", result.Data)
+	return template.HTML(buffer.String())
+}
+
+func writeHtmlValue(buffer *bytes.Buffer, result *probe.Result, path string, value interface{}) {
+	switch probe.Kind(value) {
+	case "map":
+		v := reflect.ValueOf(value)
+		buffer.WriteString("<table>")
+		for _, key := range probe.SortedKeys(value) {
+			buffer.WriteString("<tr><td>")
+			buffer.WriteString(template.HTMLEscapeString(key))
+			buffer.WriteString("</td><td>")
+			writeHtmlValue(buffer, result, joinPath(path, key), v.MapIndex(reflect.ValueOf(key)).Interface())
+			buffer.WriteString("</td></tr>")
+		}
+		buffer.WriteString("</table>")
+	case "list":
+		v := reflect.ValueOf(value)
+		buffer.WriteString("<table>")
+		for i := 0; i < v.Len(); i++ {
+			buffer.WriteString("<tr><td>")
+			writeHtmlValue(buffer, result, joinPath(path, strconv.Itoa(i)), v.Index(i).Interface())
+			buffer.WriteString("</td></tr>")
+		}
+		buffer.WriteString("</table>")
+	default:
+		buffer.WriteString(template.HTMLEscapeString(fmt.Sprintf("%v", value)))
+		if unit := result.Unit(path); unit != "" {
+			buffer.WriteString(" " + template.HTMLEscapeString(unit))
+		}
+	}
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
 func respondHtml(w http.ResponseWriter, req *http.Request, val interface{}) int {
 	w.Header().Set("Content-Type", ContentTypeHtml)
 	if val == nil {
@@ -273,7 +647,7 @@ func respondHtml(w http.ResponseWriter, req *http.Request, val interface{}) int
 	var buffer bytes.Buffer
 	var err error
 	switch val.(type) {
-	case []*probe.Result:
+	case *probe.Report:
 		err = listTemplate.Execute(&buffer, val)
 	case *probe.Result:
 		err = resultTemplate.Execute(&buffer, val)
@@ -293,8 +667,10 @@ func respondJSON(w http.ResponseWriter, req *http.Request, val interface{}) int
 	if val == nil {
 		val = make(map[string]string)
 	}
-	prettyParam := req.FormValue("pretty")
-	pretty := prettyParam != "" && prettyParam != "false"
+	pretty := outputConfig(req).Pretty
+	if req.FormValue("pretty") != "" {
+		pretty = boolParam(req, "pretty")
+	}
 	var bytes []byte
 	var err error
 	if pretty {
@@ -306,7 +682,7 @@ func respondJSON(w http.ResponseWriter, req *http.Request, val interface{}) int
 	if err == nil {
 		w.Write(bytes)
 	} else {
-		respondError(w, req, "Error serializing to JSON: "+err.Error(), http.StatusInternalServerError)
+		respondError(w, req, NewHttpError(http.StatusInternalServerError, "Error serializing to JSON: "+err.Error()))
 	}
 	return len(bytes)
 }
@@ -317,7 +693,63 @@ func respondYAML(w http.ResponseWriter, req *http.Request, val interface{}) int
 	if err == nil {
 		w.Write(bytes)
 	} else {
-		respondError(w, req, "Error serializing to YAML: "+err.Error(), http.StatusInternalServerError)
+		respondError(w, req, NewHttpError(http.StatusInternalServerError, "Error serializing to YAML: "+err.Error()))
 	}
 	return len(bytes)
 }
+
+// respondCSV writes a row per data value of each result, with the columns
+// probe, key, value and unit. A failed result gets an error row instead.
+func respondCSV(w http.ResponseWriter, req *http.Request, val interface{}) int {
+	w.Header().Set("Content-Type", ContentTypeCSV+"; charset=utf-8")
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	writer.Write([]string{"probe", "key", "value", "unit"})
+	var results []*probe.Result
+	switch v := val.(type) {
+	case *probe.Report:
+		results = v.Results
+	case *probe.Result:
+		results = []*probe.Result{v}
+	default:
+		for _, key := range probe.SortedKeys(val) {
+			writer.Write([]string{"", key, fmt.Sprintf("%v", reflect.ValueOf(val).MapIndex(reflect.ValueOf(key))), ""})
+		}
+	}
+	for _, result := range results {
+		if result.Status != probe.StatusOK {
+			writer.Write([]string{result.Name, result.Status, result.Error, ""})
+			continue
+		}
+		for _, row := range dataRows(result) {
+			writer.Write([]string{result.Name, row.path, row.value, row.unit})
+		}
+	}
+	writer.Flush()
+	w.Write(buffer.Bytes())
+	return buffer.Len()
+}
+
+// respondNDJSON writes each result of a report as a JSON object on its own
+// line, other values as a single line.
+func respondNDJSON(w http.ResponseWriter, req *http.Request, val interface{}) int {
+	w.Header().Set("Content-Type", ContentTypeNDJSON)
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	var err error
+	if report, ok := val.(*probe.Report); ok {
+		for _, result := range report.Results {
+			if err = encoder.Encode(result); err != nil {
+				break
+			}
+		}
+	} else {
+		err = encoder.Encode(val)
+	}
+	if err != nil {
+		respondError(w, req, NewHttpError(http.StatusInternalServerError, "Error serializing to JSON: "+err.Error()))
+		return 0
+	}
+	w.Write(buffer.Bytes())
+	return buffer.Len()
+}
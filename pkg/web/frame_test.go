@@ -0,0 +1,146 @@
+package web
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jolestar/go-probe/pkg/probe"
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// newTestFrame returns an initialized frame of the default config, changed by
+// mutate unless nil. The probe settings it applies are reset after the test.
+func newTestFrame(t *testing.T, mutate func(*Config)) *Frame {
+	t.Helper()
+	config := DefaultConfig()
+	if mutate != nil {
+		mutate(config)
+	}
+	f, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { probe.Configure(DefaultConfig().probeConfig()) })
+	f.Init()
+	return f
+}
+
+func TestNotFoundEnvelope(t *testing.T) {
+	f := newTestFrame(t, nil)
+
+	for _, accept := range []string{"application/json", "application/yaml"} {
+		req := httptest.NewRequest("GET", "/nosuch", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		f.router.ServeHTTP(w, req)
+		assert.Equal(t, 404, w.Code)
+		assert.Equal(t, accept, w.Header().Get("Content-Type"))
+		var envelope ErrorEnvelope
+		if accept == "application/json" {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+		} else {
+			assert.NoError(t, yaml.Unmarshal(w.Body.Bytes(), &envelope))
+		}
+		assert.Equal(t, ErrorEnvelope{
+			Code:      404,
+			Type:      "ERROR",
+			Message:   "No such probe [nosuch]",
+			RequestID: w.Header().Get("X-RequestID"),
+			Probe:     "nosuch",
+		}, envelope)
+	}
+
+	req := httptest.NewRequest("GET", "/nosuch?format=csv", nil)
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	assert.Equal(t, "code,type,message,request_id,probe\n404,ERROR,No such probe [nosuch],REQ-3,nosuch\n", w.Body.String())
+}
+
+func TestProbeErrorEnvelope(t *testing.T) {
+	f := newTestFrame(t, nil)
+
+	tests := []struct {
+		path    string
+		code    int
+		probe   string
+		message string
+	}{
+		{"/process/99999999999", 400, "process", "Invalid pid [99999999999]"},
+		{"/process/2147483647", 404, "process", "No such process [2147483647]"},
+		{"/env?pid=abc", 400, "env", "Invalid pid [abc]"},
+		{"/connections?kind=bogus", 400, "connections", "Invalid kind [bogus]"},
+		{"/dns/resolve", 400, "dns-resolve", "Missing name parameter"},
+		{"/check/tcp?target=nohost", 400, "check-tcp", "Invalid target [nohost], expect host:port"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		f.router.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code, test.path)
+		var envelope ErrorEnvelope
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &envelope), test.path)
+		assert.Equal(t, ErrorEnvelope{
+			Code:      test.code,
+			Type:      "ERROR",
+			Message:   test.message,
+			RequestID: w.Header().Get("X-RequestID"),
+			Probe:     test.probe,
+		}, envelope, test.path)
+		_, counted := f.metrics.probes[test.probe]
+		assert.False(t, counted, "%s counted as a probe error", test.path)
+	}
+}
+
+func TestNoGoroutineLeak(t *testing.T) {
+	f := newTestFrame(t, nil)
+	server := httptest.NewServer(f.router)
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 8}}
+
+	// run sends n requests over 8 connections which stay open, as a load
+	// balancer polling go-probe would.
+	run := func(n int) {
+		var wg sync.WaitGroup
+		for c := 0; c < 8; c++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < n/8; i++ {
+					resp, err := client.Get(server.URL + "/status")
+					if assert.NoError(t, err) {
+						ioutil.ReadAll(resp.Body)
+						resp.Body.Close()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	// settled returns the goroutine count once it stops dropping.
+	settled := func() int {
+		count := runtime.NumGoroutine()
+		for i := 0; i < 20; i++ {
+			time.Sleep(10 * time.Millisecond)
+			if now := runtime.NumGoroutine(); now >= count {
+				return now
+			} else {
+				count = now
+			}
+		}
+		return count
+	}
+
+	run(80)
+	before := settled()
+	run(800)
+	after := settled()
+	assert.True(t, after-before < 10, "%d goroutines before, %d after 800 requests", before, after)
+}
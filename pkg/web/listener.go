@@ -0,0 +1,164 @@
+package web
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/jolestar/go-probe/pkg/probe"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// ListenerConfig is an address go-probe serves on, with the probes it
+// exposes there.
+type ListenerConfig struct {
+	// Network is tcp, the default, or unix.
+	Network string `yaml:"network"`
+	Address string `yaml:"address"`
+	// Mode is the octal permissions of a unix socket, e.g. "0660".
+	Mode string `yaml:"mode"`
+	// TLS serves HTTPS with the tls section.
+	TLS bool `yaml:"tls"`
+	// H2C serves cleartext HTTP/2 besides HTTP/1.1.
+	H2C bool `yaml:"h2c"`
+	// ProxyProtocol expects a PROXY protocol v1 or v2 header on every
+	// connection, the client it carries is the remote address.
+	ProxyProtocol bool `yaml:"proxy_protocol"`
+	// Probes lists the only probes served, all when empty.
+	Probes []string `yaml:"probes"`
+}
+
+func (l ListenerConfig) String() string {
+	s := l.Address
+	if l.Network == "unix" {
+		s = "unix:" + s
+	}
+	switch {
+	case l.TLS:
+		s += " (tls)"
+	case l.H2C:
+		s += " (h2c)"
+	}
+	if l.ProxyProtocol {
+		s += " (proxy protocol)"
+	}
+	return s
+}
+
+// listeners returns the listeners of the config, the listen address, with
+// TLS when the tls section enables it, unless listeners are declared.
+func (c *Config) listeners() []ListenerConfig {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
+	}
+	return []ListenerConfig{{Network: "tcp", Address: c.Listen, TLS: c.TLS.Enabled()}}
+}
+
+func (l ListenerConfig) validate(i int, tlsEnabled bool, known map[string]bool) []string {
+	var errs []string
+	path := fmt.Sprintf("listeners.%d", i)
+	switch l.Network {
+	case "", "tcp":
+		if _, _, err := net.SplitHostPort(l.Address); err != nil {
+			errs = append(errs, fmt.Sprintf("%s.address: %s", path, err.Error()))
+		}
+		if l.Mode != "" {
+			errs = append(errs, fmt.Sprintf("%s.mode: only for unix sockets", path))
+		}
+	case "unix":
+		if l.Address == "" {
+			errs = append(errs, fmt.Sprintf("%s.address: missing socket path", path))
+		}
+		if _, err := strconv.ParseUint(l.Mode, 8, 32); l.Mode != "" && err != nil {
+			errs = append(errs, fmt.Sprintf("%s.mode: not an octal mode [%s]", path, l.Mode))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("%s.network: unknown network [%s]", path, l.Network))
+	}
+	if l.TLS && !tlsEnabled {
+		errs = append(errs, fmt.Sprintf("%s.tls: needs cert and key or self_signed in the tls section", path))
+	}
+	if l.TLS && l.H2C {
+		errs = append(errs, fmt.Sprintf("%s: h2c excludes tls, which negotiates HTTP/2 by itself", path))
+	}
+	for _, name := range l.Probes {
+		if !known[name] {
+			errs = append(errs, fmt.Sprintf("%s.probes: no such probe [%s]", path, name))
+		}
+	}
+	return errs
+}
+
+// listen opens the listener of l. A unix socket left over by a previous run
+// is removed first.
+func (f *Frame) listen(l ListenerConfig) (net.Listener, error) {
+	ln, err := listen(l)
+	if err != nil || !l.ProxyProtocol {
+		return ln, err
+	}
+	return &proxyListener{Listener: ln, trusted: f.currentTrusted}, nil
+}
+
+// umaskLock serializes the umask changes of listen, the umask being per
+// process.
+var umaskLock sync.Mutex
+
+func listen(l ListenerConfig) (net.Listener, error) {
+	if l.Network != "unix" {
+		return net.Listen("tcp", l.Address)
+	}
+	if info, err := os.Lstat(l.Address); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(l.Address)
+	}
+	if l.Mode == "" {
+		return net.Listen("unix", l.Address)
+	}
+	// The socket is created with mode through the umask, rather than
+	// chmod'ed after bind, so it is never reachable with looser permissions.
+	mode, _ := strconv.ParseUint(l.Mode, 8, 32)
+	umaskLock.Lock()
+	defer umaskLock.Unlock()
+	umask := syscall.Umask(0777 &^ int(mode))
+	defer syscall.Umask(umask)
+	return net.Listen("unix", l.Address)
+}
+
+// newServer returns the server of listener l, with the timeouts of the
+// server section, which only runs the probes the listener exposes.
+func (f *Frame) newServer(l ListenerConfig) *http.Server {
+	handler := http.Handler(f.router)
+	if len(l.Probes) > 0 {
+		exposed := map[string]bool{}
+		for _, name := range l.Probes {
+			exposed[name] = true
+		}
+		handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ctx := probe.WithFilter(req.Context(), func(name string) bool {
+				return exposed[name]
+			})
+			f.router.ServeHTTP(w, req.WithContext(ctx))
+		})
+	}
+	current := f.currentConfig()
+	config := current.Server
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      current.writeTimeout(),
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+	if l.TLS {
+		server.TLSConfig = &tls.Config{GetConfigForClient: f.currentTLSConfig}
+	}
+	if l.H2C {
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return server
+}
@@ -0,0 +1,89 @@
+package web
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenerProbes(t *testing.T) {
+	f := newTestFrame(t, nil)
+	handler := f.newServer(ListenerConfig{Address: ":8080", Probes: []string{"status"}}).Handler
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path+"?format=json", nil))
+		return w
+	}
+	assert.Equal(t, 200, serve("/status").Code)
+	assert.Equal(t, 404, serve("/env").Code)
+	w := serve("/")
+	assert.Equal(t, 200, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `"status"`))
+	assert.False(t, strings.Contains(w.Body.String(), `"env"`))
+}
+
+func TestUnixListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-probe-listener")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	f := newTestFrame(t, func(config *Config) {
+		config.Listeners = []ListenerConfig{{Network: "unix", Address: filepath.Join(dir, "probe.sock"), Mode: "0600"}}
+	})
+
+	l := f.currentConfig().Listeners[0]
+	umask := syscall.Umask(0)
+	syscall.Umask(umask)
+	ln, err := listen(l)
+	if !assert.NoError(t, err) {
+		return
+	}
+	restored := syscall.Umask(umask)
+	assert.Equal(t, umask, restored, "the umask is restored")
+	info, err := os.Stat(l.Address)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+	server := f.newServer(l)
+	go server.Serve(ln)
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", l.Address)
+		},
+	}}
+	resp, err := client.Get("http://go-probe/status?format=json")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, 200, resp.StatusCode)
+	}
+}
+
+func TestListenersValidate(t *testing.T) {
+	config := DefaultConfig()
+	config.Listeners = []ListenerConfig{
+		{Address: ":8080", TLS: true, H2C: true},
+		{Network: "unix", Address: "/run/probe.sock", Mode: "rw"},
+		{Network: "udp", Address: ":53"},
+		{Address: ":8080", Mode: "0600", Probes: []string{"nope"}},
+	}
+	err := config.Validate()
+	if assert.Error(t, err) {
+		for _, expected := range []string{"listeners.0.tls: needs cert", "listeners.0: h2c excludes tls",
+			"listeners.1.mode: not an octal mode [rw]", "listeners.2.network: unknown network [udp]",
+			"listeners.3.mode: only for unix sockets", "listeners.3.probes: no such probe [nope]",
+			"listeners.3.address: duplicate [:8080]"} {
+			assert.True(t, strings.Contains(err.Error(), expected), expected)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jolestar/go-probe/pkg/httputil"
+	"github.com/jolestar/go-probe/pkg/probe"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	ContentTypePrometheus  = "text/plain; version=0.0.4; charset=utf-8"
+	ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// metricProbes are the probes /metrics runs and exports the numeric data of,
+// the others do not run on scrapes.
+var metricProbes = map[string]bool{
+	"memory-info": true,
+	"load-avg":    true,
+	"disk-usage":  true,
+	"network-io":  true,
+	"cgroup":      true,
+}
+
+// metricLabels are the fields which identify the list entries of each metric
+// probe and label their values. Other strings, error messages included, are
+// no labels, which would make series come and go.
+var metricLabels = map[string][]string{
+	"disk-usage": {"Path", "Device"},
+	"network-io": {"Name"},
+	"cgroup":     {"Device"},
+}
+
+// requestBuckets are the upper bounds in seconds of the request latency
+// histogram.
+var requestBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricsHandler serves the numeric data of the probes and the metrics of
+// go-probe itself in the Prometheus text format, or in OpenMetrics when the
+// scraper accepts it.
+func (f *Frame) metricsHandler(w http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	requestID := f.generateRequestID()
+	auth := f.currentAuth()
+	user, err := auth.Authenticate(req)
+	w.Header().Add("X-RequestID", requestID)
+	if err != nil {
+		httpErr := auth.unauthorized(err.Error())
+		for k, v := range httpErr.Header {
+			w.Header()[k] = v
+		}
+		respondError(w, req, httpErr)
+		f.errorLog(requestID, httpErr.Status, httpErr.Message)
+		f.requestLog(req, RequestLog{RequestID: requestID, ResponseStatus: httpErr.Status,
+			ResponseTime: int64(time.Since(start) / time.Millisecond), AuthDenied: httpErr.Message})
+		return
+	}
+	ctx := withUser(probe.WithRequestID(req.Context(), requestID), user)
+	ctx, _ = f.authorize(ctx, "")
+	ctx = probe.WithFilter(ctx, func(name string) bool {
+		return metricProbes[name]
+	})
+	ctx = probe.WithParams(ctx, req.URL.Query())
+	r, _ := probe.DoProbe(ctx, "")
+	set := newMetricSet()
+	for _, result := range r.(*probe.Report).Results {
+		f.metrics.observeResult(result)
+		up := 0.0
+		if result.Status == probe.StatusOK {
+			up = 1
+			set.addResult(result)
+		}
+		set.add("goprobe_probe_up", "gauge", "Whether the probe succeeded in this scrape.", "",
+			[]metricLabel{{"probe", result.Name}}, up)
+	}
+	f.metrics.addTo(set)
+
+	openMetrics := httputil.NegotiateContentType(req, []string{"text/plain", "application/openmetrics-text"}, "text/plain") == "application/openmetrics-text"
+	bw := newBufferedWriter(w)
+	set.write(&bw.body, openMetrics)
+	if openMetrics {
+		w.Header().Set("Content-Type", ContentTypeOpenMetrics)
+	} else {
+		w.Header().Set("Content-Type", ContentTypePrometheus)
+	}
+	w.Header().Add("Vary", "Accept")
+	reqLog := RequestLog{
+		RequestID:      requestID,
+		User:           user,
+		ResponseStatus: http.StatusOK,
+		ResponseTime:   int64(time.Since(start) / time.Millisecond),
+		ResponseSize:   bw.body.Len(),
+	}
+	reqLog.ResponseWireSize = bw.flush(req, f.currentConfig().Output)
+	f.requestLog(req, reqLog)
+}
+
+// metrics counts the requests served and the probe runs seen by the frame.
+type metrics struct {
+	lock     sync.Mutex
+	requests map[int]*histogram
+	probes   map[string]*probeStats
+}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+type probeStats struct {
+	runs  map[string]uint64
+	count uint64
+	sum   float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{requests: map[int]*histogram{}, probes: map[string]*probeStats{}}
+}
+
+func (m *metrics) observeRequest(reqLog RequestLog) {
+	seconds := float64(reqLog.ResponseTime) / 1000
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, ok := m.requests[reqLog.ResponseStatus]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(requestBuckets))}
+		m.requests[reqLog.ResponseStatus] = h
+	}
+	for i, bound := range requestBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (m *metrics) stats(name string) *probeStats {
+	stats, ok := m.probes[name]
+	if !ok {
+		stats = &probeStats{runs: map[string]uint64{}}
+		m.probes[name] = stats
+	}
+	return stats
+}
+
+func (m *metrics) observeResult(result *probe.Result) {
+	duration, err := time.ParseDuration(result.Duration)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stats := m.stats(result.Name)
+	stats.runs[result.Status]++
+	if err == nil {
+		stats.count++
+		stats.sum += duration.Seconds()
+	}
+}
+
+// observeError counts a failed run of a single probe, whose result is not
+// returned by DoProbe.
+func (m *metrics) observeError(name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.stats(name).runs[probe.StatusError]++
+}
+
+func (m *metrics) addTo(set *metricSet) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for status, h := range m.requests {
+		code := strconv.Itoa(status)
+		name := "goprobe_http_request_duration_seconds"
+		help := "Latency of the requests served, by status code."
+		for i, bound := range requestBuckets {
+			set.add(name, "histogram", help, "_bucket", []metricLabel{{"code", code}, {"le", formatFloat(bound)}}, float64(h.buckets[i]))
+		}
+		set.add(name, "histogram", help, "_bucket", []metricLabel{{"code", code}, {"le", "+Inf"}}, float64(h.count))
+		set.add(name, "histogram", help, "_sum", []metricLabel{{"code", code}}, h.sum)
+		set.add(name, "histogram", help, "_count", []metricLabel{{"code", code}}, float64(h.count))
+	}
+	for name, stats := range m.probes {
+		for status, runs := range stats.runs {
+			set.add("goprobe_probe_runs", "counter", "Runs of the probe, by status.", "_total",
+				[]metricLabel{{"probe", name}, {"status", status}}, float64(runs))
+		}
+		if stats.count > 0 {
+			help := "Duration of the probe runs."
+			set.add("goprobe_probe_duration_seconds", "summary", help, "_sum", []metricLabel{{"probe", name}}, stats.sum)
+			set.add("goprobe_probe_duration_seconds", "summary", help, "_count", []metricLabel{{"probe", name}}, float64(stats.count))
+		}
+	}
+}
+
+type metricLabel struct {
+	name  string
+	value string
+}
+
+type metricSample struct {
+	suffix string
+	labels []metricLabel
+	value  float64
+}
+
+type metricFamily struct {
+	name    string
+	typ     string
+	help    string
+	samples []metricSample
+	seen    map[string]bool
+}
+
+// metricSet collects samples grouped by family, as the exposition formats
+// require.
+type metricSet struct {
+	families map[string]*metricFamily
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{families: map[string]*metricFamily{}}
+}
+
+// add records a sample of the family name. A sample whose labels were seen
+// before, such as a filesystem mounted twice, is dropped.
+func (s *metricSet) add(name string, typ string, help string, suffix string, labels []metricLabel, value float64) {
+	family, ok := s.families[name]
+	if !ok {
+		family = &metricFamily{name: name, typ: typ, help: help, seen: map[string]bool{}}
+		s.families[name] = family
+	}
+	key := suffix + formatLabels(labels)
+	if family.seen[key] {
+		return
+	}
+	family.seen[key] = true
+	family.samples = append(family.samples, metricSample{suffix: suffix, labels: labels, value: value})
+}
+
+// addResult exports the numeric values of result, named after the probe and
+// the path of the value. The identifying fields of list elements, such as the
+// path of a mount, become the labels of its values, and elements which failed
+// are left out.
+func (s *metricSet) addResult(result *probe.Result) {
+	s.addValue(result, "goprobe_"+metricName(result.Name), "", nil, result.Data)
+}
+
+func (s *metricSet) addValue(result *probe.Result, prefix string, path string, labels []metricLabel, value interface{}) {
+	switch probe.Kind(value) {
+	case "map":
+		v := reflect.ValueOf(value)
+		for _, key := range probe.SortedKeys(value) {
+			s.addValue(result, prefix, joinPath(path, key), labels, v.MapIndex(reflect.ValueOf(key)).Interface())
+		}
+	case "list":
+		v := reflect.ValueOf(value)
+		for i := 0; i < v.Len(); i++ {
+			item := v.Index(i).Interface()
+			if probe.Kind(item) == "map" && reflect.ValueOf(item).MapIndex(reflect.ValueOf("Error")).IsValid() {
+				continue
+			}
+			itemLabels := append(append([]metricLabel{}, labels...), listItemLabels(result.Name, item)...)
+			s.addValue(result, prefix, joinPath(path, strconv.Itoa(i)), itemLabels, item)
+		}
+	default:
+		number, ok := metricValue(value)
+		if !ok {
+			return
+		}
+		name := prefix + "_" + metricName(probe.UnitPath(path))
+		unit := result.Unit(path)
+		switch unit {
+		case probe.UnitBytes:
+			if !strings.Contains(name, "bytes") {
+				name += "_bytes"
+			}
+		case probe.UnitSeconds:
+			if !strings.Contains(name, "seconds") {
+				name += "_seconds"
+			}
+		}
+		help := fmt.Sprintf("Value %s of the %s probe.", probe.UnitPath(path), result.Name)
+		if unit != "" {
+			help = fmt.Sprintf("Value %s of the %s probe, in %s.", probe.UnitPath(path), result.Name, unit)
+		}
+		if result.IsCounter(path) {
+			s.add(name, "counter", help, "_total", labels, number)
+		} else {
+			s.add(name, "gauge", help, "", labels, number)
+		}
+	}
+}
+
+// listItemLabels returns the metricLabels fields of a list element of probe
+// name as labels.
+func listItemLabels(name string, item interface{}) []metricLabel {
+	if probe.Kind(item) != "map" {
+		return nil
+	}
+	var labels []metricLabel
+	v := reflect.ValueOf(item)
+	for _, key := range metricLabels[name] {
+		field := v.MapIndex(reflect.ValueOf(key))
+		if !field.IsValid() {
+			continue
+		}
+		if value, ok := field.Interface().(string); ok {
+			labels = append(labels, metricLabel{metricName(key), value})
+		}
+	}
+	return labels
+}
+
+// metricValue converts a scalar to a sample value. A cgroup limit of "max" is
+// +Inf, so usage/limit ratios keep working.
+func metricValue(value interface{}) (float64, bool) {
+	if value == "max" {
+		return math.Inf(1), true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// metricName converts a probe name or a CamelCase key to snake case,
+// e.g. OOMKills to oom_kills.
+func metricName(s string) string {
+	runes := []rune(s)
+	var buffer bytes.Buffer
+	for i, r := range runes {
+		if !(r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))) {
+			buffer.WriteByte('_')
+			continue
+		}
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+				(unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				buffer.WriteByte('_')
+			}
+		}
+		buffer.WriteRune(unicode.ToLower(r))
+	}
+	return buffer.String()
+}
+
+// write renders the set in the text format, or in OpenMetrics which names
+// counter families without their _total suffix and ends with # EOF.
+func (s *metricSet) write(w io.Writer, openMetrics bool) {
+	names := make([]string, 0, len(s.families))
+	for name := range s.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		family := s.families[name]
+		familyName := name
+		if family.typ == "counter" && !openMetrics {
+			familyName += "_total"
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n", familyName, family.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", familyName, family.typ)
+		for _, sample := range family.samples {
+			fmt.Fprintf(w, "%s%s%s %s\n", name, sample.suffix, formatLabels(sample.labels), formatFloat(sample.value))
+		}
+	}
+	if openMetrics {
+		fmt.Fprint(w, "# EOF\n")
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []metricLabel) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, len(labels))
+	for i, label := range labels {
+		parts[i] = fmt.Sprintf(`%s="%s"`, label.name, labelEscaper.Replace(label.value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,71 @@
+package web
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jolestar/go-probe/pkg/probe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricName(t *testing.T) {
+	for s, name := range map[string]string{
+		"network-io":         "network_io",
+		"OOMKills":           "oom_kills",
+		"Load15":             "load15",
+		"memory.UsedPercent": "memory_used_percent",
+		"RSS":                "rss",
+	} {
+		assert.Equal(t, name, metricName(s))
+	}
+}
+
+func TestMetricSetWrite(t *testing.T) {
+	result := probe.NewResult("cgroup")
+	result.Set("memory", map[string]interface{}{"Limit": "max", "Usage": uint64(1024), "UnderOOM": false})
+	result.Set("throttle", []interface{}{
+		map[string]interface{}{"Device": "8:0", "Model": "disk", "ReadBps": 10},
+		map[string]interface{}{"Device": "8:0", "ReadBps": 10},
+		map[string]interface{}{"Device": "8:16", "Error": "no such device", "ReadBps": 20},
+	})
+	result.AddUnit("memory.Usage", probe.UnitBytes)
+	result.AddCounter("memory.Usage")
+	set := newMetricSet()
+	set.addResult(result)
+
+	var buffer bytes.Buffer
+	set.write(&buffer, false)
+	assert.Equal(t, `# HELP goprobe_cgroup_memory_limit Value memory.Limit of the cgroup probe.
+# TYPE goprobe_cgroup_memory_limit gauge
+goprobe_cgroup_memory_limit +Inf
+# HELP goprobe_cgroup_memory_under_oom Value memory.UnderOOM of the cgroup probe.
+# TYPE goprobe_cgroup_memory_under_oom gauge
+goprobe_cgroup_memory_under_oom 0
+# HELP goprobe_cgroup_memory_usage_bytes_total Value memory.Usage of the cgroup probe, in bytes.
+# TYPE goprobe_cgroup_memory_usage_bytes_total counter
+goprobe_cgroup_memory_usage_bytes_total 1024
+# HELP goprobe_cgroup_throttle_read_bps Value throttle.ReadBps of the cgroup probe.
+# TYPE goprobe_cgroup_throttle_read_bps gauge
+goprobe_cgroup_throttle_read_bps{device="8:0"} 10
+`, buffer.String())
+
+	buffer.Reset()
+	set.write(&buffer, true)
+	assert.Contains(t, buffer.String(), "# TYPE goprobe_cgroup_memory_usage_bytes counter\ngoprobe_cgroup_memory_usage_bytes_total 1024\n")
+	assert.True(t, bytes.HasSuffix(buffer.Bytes(), []byte("# EOF\n")))
+}
+
+func TestMetricsHandlerRunsMetricProbes(t *testing.T) {
+	f := newTestFrame(t, nil)
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics?interval=10ms", nil))
+	assert.Equal(t, 200, w.Code)
+	body := w.Body.String()
+	for name := range metricProbes {
+		assert.Contains(t, body, `goprobe_probe_up{probe="`+name+`"}`)
+	}
+	for _, name := range []string{"processes", "connections", "env"} {
+		assert.NotContains(t, body, `probe="`+name+`"`)
+	}
+}
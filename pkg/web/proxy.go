@@ -0,0 +1,116 @@
+package web
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ProxyConfig lists the proxies in front of go-probe, whose forwarding
+// headers tell the address of the client.
+type ProxyConfig struct {
+	// Trusted are CIDRs or addresses, e.g. 10.0.0.0/8. Without any the
+	// forwarding headers are ignored.
+	Trusted []string `yaml:"trusted"`
+}
+
+// trustedProxies are the parsed networks of ProxyConfig.Trusted.
+type trustedProxies []*net.IPNet
+
+func parseTrusted(trusted []string) (trustedProxies, error) {
+	var networks trustedProxies
+	for _, item := range trusted {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("not an address or CIDR [%s]", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("not an address or CIDR [%s]", item)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// contains reports whether address is in one of the trusted networks.
+func (t trustedProxies) contains(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range t {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestIP returns the address of the client of req. The forwarding headers
+// are only believed when the peer is a trusted proxy, Forwarded first, then
+// X-Forwarded-For and X-Real-IP. Their hops are walked from the nearest one,
+// skipping trusted proxies, so a client cannot pass for another by sending
+// the headers itself.
+func (f *Frame) requestIP(req *http.Request) string {
+	peer, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		// Unix socket peers have no host and port.
+		return req.RemoteAddr
+	}
+	trusted := f.currentTrusted()
+	if !trusted.contains(peer) {
+		return peer
+	}
+	hops := forwardedFor(req.Header)
+	if len(hops) == 0 {
+		hops = SplitList(strings.Join(req.Header["X-Forwarded-For"], ","))
+	}
+	if len(hops) == 0 {
+		if realIP := strings.TrimSpace(req.Header.Get("X-Real-IP")); realIP != "" {
+			hops = []string{realIP}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hopAddress(hops[i])
+		if i == 0 || !trusted.contains(hop) {
+			return hop
+		}
+	}
+	return peer
+}
+
+// forwardedFor returns the for parameters of the RFC 7239 Forwarded headers
+// of h, from the farthest hop to the nearest.
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	for _, header := range h["Forwarded"] {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					hops = append(hops, strings.Trim(kv[1], `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// hopAddress strips the port and brackets of a forwarded address, such as
+// [2001:db8::1]:4711. Obfuscated identifiers and "unknown" are kept as is.
+func hopAddress(hop string) string {
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")
+}
@@ -0,0 +1,161 @@
+package web
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestIP(t *testing.T) {
+	f := newTestFrame(t, func(config *Config) {
+		config.Proxy.Trusted = []string{"10.0.0.0/8", "2001:db8::1"}
+	})
+
+	tests := []struct {
+		remote  string
+		headers map[string]string
+		ip      string
+	}{
+		{"192.0.2.1:1234", nil, "192.0.2.1"},
+		{"192.0.2.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "192.0.2.1"},
+		{"10.1.2.3:1234", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		{"10.1.2.3:1234", map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.7, 10.9.9.9"}, "198.51.100.7"},
+		{"10.1.2.3:1234", map[string]string{"X-Forwarded-For": "10.8.8.8, 10.9.9.9"}, "10.8.8.8"},
+		{"10.1.2.3:1234", map[string]string{"X-Real-IP": "198.51.100.8"}, "198.51.100.8"},
+		{"[2001:db8::1]:1234", map[string]string{"Forwarded": `for=6.6.6.6, for="[2001:db8::7]:4711";proto=https`,
+			"X-Forwarded-For": "198.51.100.7"}, "2001:db8::7"},
+		{"10.1.2.3:1234", map[string]string{"Forwarded": "for=unknown"}, "unknown"},
+		{"@", nil, "@"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/request-info", nil)
+		req.RemoteAddr = test.remote
+		for k, v := range test.headers {
+			req.Header.Set(k, v)
+		}
+		assert.Equal(t, test.ip, f.requestIP(req), "%s %v", test.remote, test.headers)
+	}
+
+	req := httptest.NewRequest("GET", "/request-info?format=json", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	var result struct{ Data map[string]interface{} }
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, "198.51.100.7", result.Data["ClientIP"])
+
+	config := DefaultConfig()
+	config.Proxy.Trusted = []string{"10.0.0.0/33"}
+	assert.Error(t, config.Validate())
+}
+
+func TestProxyProtocol(t *testing.T) {
+	v2 := func(command byte, family byte, addresses []byte) string {
+		header := append([]byte{}, proxyV2Signature...)
+		header = append(header, 0x20|command, family, 0, 0)
+		binary.BigEndian.PutUint16(header[14:], uint16(len(addresses)))
+		return string(append(header, addresses...))
+	}
+	ipv4 := []byte{198, 51, 100, 7, 10, 0, 0, 1, 0x30, 0x39, 0, 80}
+
+	tests := []struct {
+		header string
+		remote string
+		err    bool
+	}{
+		{"PROXY TCP4 198.51.100.7 10.0.0.1 12345 80\r\n", "198.51.100.7:12345", false},
+		{"PROXY TCP6 2001:db8::7 2001:db8::1 4711 80\r\n", "[2001:db8::7]:4711", false},
+		{"PROXY UNKNOWN\r\n", "", false},
+		{v2(1, 0x11, ipv4), "198.51.100.7:12345", false},
+		{v2(0, 0x00, nil), "", false},
+		{"PROXY TCP4 198.51.100.7\r\n", "", true},
+		{"GET / HTTP/1.1\r\n", "", true},
+	}
+	for _, test := range tests {
+		server, client := net.Pipe()
+		go func() {
+			client.Write([]byte(test.header + "rest"))
+			client.Close()
+		}()
+		conn := &proxyConn{Conn: server}
+		remote := conn.RemoteAddr().String()
+		buffer := make([]byte, 4)
+		n, err := conn.Read(buffer)
+		if test.err {
+			assert.Error(t, err, test.header)
+			server.Close()
+			continue
+		}
+		if assert.NoError(t, err, test.header) {
+			assert.Equal(t, "rest", string(buffer[:n]), test.header)
+		}
+		if test.remote != "" {
+			assert.Equal(t, test.remote, remote, test.header)
+		} else {
+			assert.Equal(t, server.RemoteAddr().String(), remote, test.header)
+		}
+		server.Close()
+	}
+
+	// A TCP peer is only trusted when listed, with nothing trusted all fail.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ln.Close()
+	proxyLn := &proxyListener{Listener: ln, trusted: func() trustedProxies { return nil }}
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+	client.Write([]byte("PROXY TCP4 198.51.100.7 10.0.0.1 12345 80\r\nrest"))
+	conn, err := proxyLn.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Read(make([]byte, 4))
+	assert.EqualError(t, err, "PROXY header from untrusted peer "+client.LocalAddr().String())
+}
+
+func TestProxyListener(t *testing.T) {
+	config := DefaultConfig()
+	config.Listeners = []ListenerConfig{{Address: "127.0.0.1:0", ProxyProtocol: true}}
+	assert.Error(t, config.Validate(), "proxy_protocol without proxy.trusted")
+	f := newTestFrame(t, func(c *Config) {
+		c.Listeners = config.Listeners
+		c.Proxy.Trusted = []string{"127.0.0.1"}
+	})
+	ln, err := f.listen(config.Listeners[0])
+	if !assert.NoError(t, err) {
+		return
+	}
+	server := f.newServer(config.Listeners[0])
+	go server.Serve(ln)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte("PROXY TCP4 198.51.100.7 10.0.0.1 12345 80\r\nGET /request-info?format=json HTTP/1.1\r\nHost: go-probe\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	var result struct{ Data map[string]interface{} }
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, "198.51.100.7", result.Data["ClientIP"])
+	assert.True(t, strings.HasPrefix(result.Data["RemoteAddr"].(string), "198.51.100.7:"))
+}
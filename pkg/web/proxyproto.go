@@ -0,0 +1,179 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds the wait for the PROXY header of a connection.
+const proxyHeaderTimeout = 5 * time.Second
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyListener accepts connections which start with a PROXY protocol v1 or
+// v2 header, as sent by HAProxy or a cloud load balancer, and reports the
+// client it carries as their remote address.
+type proxyListener struct {
+	net.Listener
+	// trusted returns the proxies allowed to connect over TCP, none when
+	// empty. Peers on a unix socket are local and always allowed.
+	trusted func() trustedProxies
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, trusted: l.trusted()}, nil
+}
+
+// proxyConn reads the PROXY header on first use rather than in Accept, so a
+// slow proxy does not hold the other connections back. A connection without
+// a valid header fails on read.
+type proxyConn struct {
+	net.Conn
+	trusted trustedProxies
+	once    sync.Once
+	reader  *bufio.Reader
+	remote  net.Addr
+	err     error
+	// deadline is the read deadline the server set, restored once the
+	// header is read.
+	deadline time.Time
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.remote = c.Conn.RemoteAddr()
+		if tcp, ok := c.remote.(*net.TCPAddr); ok && !c.trusted.contains(tcp.IP.String()) {
+			c.err = fmt.Errorf("PROXY header from untrusted peer %s", c.remote)
+			return
+		}
+		c.reader = bufio.NewReader(c.Conn)
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		source, err := readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(c.deadline)
+		if err != nil {
+			c.err = fmt.Errorf("PROXY header from %s: %s", c.remote, err.Error())
+			return
+		}
+		if source != nil {
+			c.remote = source
+		}
+	})
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remote
+}
+
+// readProxyHeader reads a v1 or v2 header and returns the source address it
+// carries, nil for a health check of the proxy itself (v1 UNKNOWN, v2 LOCAL)
+// or an address family other than TCP over IPv4 or IPv6.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	signature, err := r.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(signature, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if prefix, err := r.Peek(6); err != nil || string(prefix) != "PROXY " {
+		return nil, errors.New("missing header")
+	}
+	return readProxyV1(r)
+}
+
+// readProxyV1 reads "PROXY TCP4 <src> <dst> <sport> <dport>\r\n", 107 bytes
+// at most.
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("v1 header too long")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("bad v1 header %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("bad v1 source %s:%s", fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 reads the binary header: the signature, version and command,
+// address family, length, then the addresses and TLVs, which are skipped.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("bad v2 version %d", header[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	switch header[12] & 0x0f {
+	case 0x0:
+		return nil, nil
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("bad v2 command %d", header[12]&0x0f)
+	}
+	switch header[13] {
+	case 0x11:
+		if len(body) < 12 {
+			return nil, errors.New("short v2 IPv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21:
+		if len(body) < 36 {
+			return nil, errors.New("short v2 IPv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,195 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"github.com/jolestar/go-probe/pkg/probe"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ServerConfig hardens the HTTP servers of the listeners and sets how they
+// shut down.
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	// WriteTimeout must leave the probes time to run. When 0 it is derived
+	// from the probe timeouts, see Config.writeTimeout.
+	WriteTimeout   time.Duration `yaml:"write_timeout"`
+	IdleTimeout    time.Duration `yaml:"idle_timeout"`
+	MaxHeaderBytes int           `yaml:"max_header_bytes"`
+	// ShutdownDelay is how long the status probe fails before the listeners
+	// close, so load balancers stop routing to go-probe first.
+	ShutdownDelay time.Duration `yaml:"shutdown_delay"`
+	// ShutdownGrace bounds the wait for the requests in flight.
+	ShutdownGrace time.Duration `yaml:"shutdown_grace"`
+}
+
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    64 << 10,
+		ShutdownDelay:     5 * time.Second,
+		ShutdownGrace:     30 * time.Second,
+	}
+}
+
+func (c ServerConfig) validate(probes ProbesConfig) []string {
+	var errs []string
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read_header_timeout", c.ReadHeaderTimeout},
+		{"read_timeout", c.ReadTimeout},
+		{"write_timeout", c.WriteTimeout},
+		{"idle_timeout", c.IdleTimeout},
+		{"shutdown_delay", c.ShutdownDelay},
+		{"shutdown_grace", c.ShutdownGrace},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			errs = append(errs, fmt.Sprintf("server.%s: must not be negative", d.name))
+		}
+	}
+	if c.MaxHeaderBytes < 0 {
+		errs = append(errs, "server.max_header_bytes: must not be negative")
+	}
+	if longest := probes.longestTimeout(); c.WriteTimeout > 0 && c.WriteTimeout <= longest {
+		errs = append(errs, fmt.Sprintf("server.write_timeout: must exceed the longest probe timeout %s", longest))
+	}
+	return errs
+}
+
+// writeTimeoutMargin is left for rendering and sending the response once the
+// probes are done.
+const writeTimeoutMargin = 10 * time.Second
+
+// longestTimeout returns the longest probe deadline, 0 when a probe may run
+// without one.
+func (c ProbesConfig) longestTimeout() time.Duration {
+	if c.Timeout == 0 {
+		return 0
+	}
+	longest := c.Timeout
+	for _, options := range c.Options {
+		if options.Timeout > longest {
+			longest = options.Timeout
+		}
+	}
+	return longest
+}
+
+// writeTimeout returns server.write_timeout, or when unset the time the
+// probes of an aggregate run may take at most, batch after batch of
+// probes.concurrency, plus a margin. It is 0, no timeout, when the probe
+// timeout is disabled.
+func (c *Config) writeTimeout() time.Duration {
+	if c.Server.WriteTimeout > 0 {
+		return c.Server.WriteTimeout
+	}
+	longest := c.Probes.longestTimeout()
+	if longest == 0 {
+		return 0
+	}
+	concurrency := c.Probes.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	batches := (len(probe.Names()) + concurrency - 1) / concurrency
+	return time.Duration(batches)*longest + writeTimeoutMargin
+}
+
+// Serve serves every listener of the config until Shutdown, it returns nil
+// then, at once when Shutdown came first. When a listener fails the others
+// are closed and its error returned.
+func (f *Frame) Serve() error {
+	listeners := f.currentConfig().listeners()
+	var servers []*http.Server
+	var lns []net.Listener
+	for _, l := range listeners {
+		ln, err := f.listen(l)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return fmt.Errorf("Listen on %s error: %s", l, err.Error())
+		}
+		lns = append(lns, ln)
+		servers = append(servers, f.newServer(l))
+	}
+	// Shutdown marks draining before it takes the servers under the lock,
+	// so it either finds them here or Serve sees it draining.
+	f.serversLock.Lock()
+	f.servers = servers
+	draining := f.isDraining()
+	f.serversLock.Unlock()
+	if draining {
+		for _, ln := range lns {
+			ln.Close()
+		}
+		return nil
+	}
+
+	errs := make(chan error, len(servers))
+	for i, server := range servers {
+		log.Printf("Listening on %s\n", listeners[i])
+		go func(server *http.Server, ln net.Listener, tls bool) {
+			if tls {
+				errs <- server.ServeTLS(ln, "", "")
+			} else {
+				errs <- server.Serve(ln)
+			}
+		}(server, lns[i], listeners[i].TLS)
+	}
+	for range servers {
+		if err := <-errs; err != http.ErrServerClosed {
+			for _, server := range servers {
+				server.Close()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Shutdown fails the status probe for the shutdown delay, then stops the
+// listeners and waits up to the shutdown grace for the requests in flight.
+func (f *Frame) Shutdown() error {
+	config := f.currentConfig().Server
+	f.draining.IncrementAndGet()
+	f.serversLock.Lock()
+	servers := f.servers
+	f.serversLock.Unlock()
+	for _, server := range servers {
+		server.SetKeepAlivesEnabled(false)
+	}
+	log.Printf("Shutting down in %s\n", config.ShutdownDelay)
+	time.Sleep(config.ShutdownDelay)
+
+	ctx := context.Background()
+	if config.ShutdownGrace > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.ShutdownGrace)
+		defer cancel()
+	}
+	var firstErr error
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			server.Close()
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// isDraining reports whether Shutdown started.
+func (f *Frame) isDraining() bool {
+	return f.draining.Get() > 0
+}
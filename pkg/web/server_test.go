@@ -0,0 +1,119 @@
+package web
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jolestar/go-probe/pkg/probe"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowAuth lets every request in, holding the ones carrying X-Slow back,
+// which stand for a slow probe.
+type slowAuth time.Duration
+
+func (s slowAuth) Authenticate(req *http.Request) (string, error) {
+	if req.Header.Get("X-Slow") != "" {
+		time.Sleep(time.Duration(s))
+	}
+	return "test", nil
+}
+
+func TestShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-probe-server")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	f := newTestFrame(t, func(config *Config) {
+		config.Listeners = []ListenerConfig{{Network: "unix", Address: filepath.Join(dir, "probe.sock")}}
+		config.Server.ShutdownDelay = 200 * time.Millisecond
+		config.Server.ShutdownGrace = 5 * time.Second
+	})
+	assert.NoError(t, f.Use(slowAuth(500*time.Millisecond)))
+	socket := f.currentConfig().Listeners[0].Address
+
+	served := make(chan error, 1)
+	go func() { served <- f.Serve() }()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	get := func(path string, slow bool) int {
+		req, _ := http.NewRequest("GET", "http://go-probe"+path, nil)
+		if slow {
+			req.Header.Set("X-Slow", "1")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for i := 0; i < 50 && get("/status", false) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 200, get("/status", false))
+
+	inFlight := make(chan int, 1)
+	go func() { inFlight <- get("/host-info", true) }()
+	time.Sleep(50 * time.Millisecond)
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- f.Shutdown() }()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 503, get("/status", false), "readiness fails during the shutdown delay")
+	assert.Equal(t, 200, get("/host-info", false))
+
+	assert.Equal(t, 200, <-inFlight, "the request in flight completes")
+	assert.NoError(t, <-shutdown)
+	assert.NoError(t, <-served)
+	assert.Equal(t, 0, get("/status", false))
+}
+
+func TestShutdownBeforeServe(t *testing.T) {
+	f := newTestFrame(t, func(config *Config) {
+		config.Listeners = []ListenerConfig{{Address: "127.0.0.1:0"}}
+		config.Server.ShutdownDelay = 0
+	})
+
+	// SIGTERM came before Serve registered the servers.
+	assert.NoError(t, f.Shutdown())
+	served := make(chan error, 1)
+	go func() { served <- f.Serve() }()
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve kept running after Shutdown")
+	}
+}
+
+func TestServerConfigValidate(t *testing.T) {
+	config := DefaultConfig()
+	config.Server.WriteTimeout = 10 * time.Second
+	config.Probes.Options = map[string]ProbeOptions{"network-io": {Timeout: 15 * time.Second}}
+	config.Server.IdleTimeout = -time.Second
+	err := config.Validate()
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "server.write_timeout: must exceed the longest probe timeout 15s"))
+		assert.True(t, strings.Contains(err.Error(), "server.idle_timeout: must not be negative"))
+	}
+	config.Server = DefaultServerConfig()
+	assert.NoError(t, config.Validate())
+	batches := (len(probe.Names()) + config.Probes.Concurrency - 1) / config.Probes.Concurrency
+	assert.Equal(t, time.Duration(batches)*15*time.Second+writeTimeoutMargin, config.writeTimeout())
+
+	// Probe timeouts of a minute or more, or none at all, still start.
+	config.Probes.Timeout = 2 * time.Minute
+	assert.NoError(t, config.Validate())
+	config.Probes.Timeout = 0
+	assert.NoError(t, config.Validate())
+	assert.Equal(t, time.Duration(0), config.writeTimeout())
+}
@@ -0,0 +1,163 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jolestar/go-probe/pkg/probe"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// dataRow is a scalar of the data of a result, by its dotted path.
+type dataRow struct {
+	path  string
+	value string
+	unit  string
+}
+
+// display returns the value followed by its unit.
+func (row dataRow) display() string {
+	if row.unit == "" {
+		return row.value
+	}
+	return row.value + " " + row.unit
+}
+
+// dataRows walks the data of result in display order, maps by key and lists
+// by index, and returns its scalars.
+func dataRows(result *probe.Result) []dataRow {
+	var rows []dataRow
+	var walk func(path string, value interface{})
+	walk = func(path string, value interface{}) {
+		switch probe.Kind(value) {
+		case "map":
+			v := reflect.ValueOf(value)
+			for _, key := range probe.SortedKeys(value) {
+				walk(joinPath(path, key), v.MapIndex(reflect.ValueOf(key)).Interface())
+			}
+		case "list":
+			v := reflect.ValueOf(value)
+			for i := 0; i < v.Len(); i++ {
+				walk(joinPath(path, strconv.Itoa(i)), v.Index(i).Interface())
+			}
+		default:
+			rows = append(rows, dataRow{path: path, value: fmt.Sprintf("%v", value), unit: result.Unit(path)})
+		}
+	}
+	walk("", result.Data)
+	return rows
+}
+
+func reportStatus(report *probe.Report) string {
+	if report.Failed > 0 {
+		return fmt.Sprintf("%s (%d failed)", report.Status, report.Failed)
+	}
+	return report.Status
+}
+
+// writeText renders a report or a result as aligned key/value columns, one
+// section per probe.
+func writeText(buffer *bytes.Buffer, val interface{}) {
+	switch v := val.(type) {
+	case *probe.Report:
+		fmt.Fprintf(buffer, "Status: %s\n", reportStatus(v))
+		for _, result := range v.Results {
+			buffer.WriteString("\n")
+			writeTextResult(buffer, result)
+		}
+	case *probe.Result:
+		writeTextResult(buffer, v)
+	default:
+		fmt.Fprintf(buffer, "%v\n", v)
+	}
+}
+
+func writeTextResult(buffer *bytes.Buffer, result *probe.Result) {
+	fmt.Fprintf(buffer, "== %s ==\n", result.Name)
+	tw := tabwriter.NewWriter(buffer, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "status\t%s\n", result.Status)
+	fmt.Fprintf(tw, "duration\t%s\n", result.Duration)
+	if result.Summary != "" {
+		fmt.Fprintf(tw, "summary\t%s\n", result.Summary)
+	}
+	if result.Error != "" {
+		fmt.Fprintf(tw, "error\t%s\n", result.Error)
+	}
+	tw.Flush()
+	rows := dataRows(result)
+	if len(rows) == 0 {
+		return
+	}
+	buffer.WriteString("\n")
+	tw = tabwriter.NewWriter(buffer, 0, 4, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s\t%s\n", row.path, oneLine(row.display()))
+	}
+	tw.Flush()
+}
+
+func oneLine(s string) string {
+	return strings.Replace(s, "\n", `\n`, -1)
+}
+
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>")
+
+// writeMarkdown renders a report or a result as Markdown, a table of the
+// probes followed by a section per probe, for pasting into tickets.
+func writeMarkdown(buffer *bytes.Buffer, val interface{}) {
+	switch v := val.(type) {
+	case *probe.Report:
+		fmt.Fprintf(buffer, "**Status:** %s\n\n", reportStatus(v))
+		buffer.WriteString("| Probe | Status | Duration | Error |\n| --- | --- | --- | --- |\n")
+		for _, result := range v.Results {
+			fmt.Fprintf(buffer, "| %s | %s | %s | %s |\n", result.Name, result.Status, result.Duration,
+				markdownEscaper.Replace(result.Error))
+		}
+		for _, result := range v.Results {
+			buffer.WriteString("\n")
+			writeMarkdownResult(buffer, result)
+		}
+	case *probe.Result:
+		writeMarkdownResult(buffer, v)
+	default:
+		fmt.Fprintf(buffer, "%v\n", v)
+	}
+}
+
+func writeMarkdownResult(buffer *bytes.Buffer, result *probe.Result) {
+	fmt.Fprintf(buffer, "## %s\n\n", result.Name)
+	fmt.Fprintf(buffer, "**Status:** %s, **Duration:** %s\n\n", result.Status, result.Duration)
+	if result.Summary != "" {
+		fmt.Fprintf(buffer, "%s\n\n", markdownEscaper.Replace(result.Summary))
+	}
+	if result.Error != "" {
+		fmt.Fprintf(buffer, "**Error:** %s\n\n", markdownEscaper.Replace(result.Error))
+	}
+	rows := dataRows(result)
+	if len(rows) == 0 {
+		return
+	}
+	buffer.WriteString("| Key | Value |\n| --- | --- |\n")
+	for _, row := range rows {
+		fmt.Fprintf(buffer, "| %s | %s |\n", markdownEscaper.Replace(row.path), markdownEscaper.Replace(row.display()))
+	}
+}
+
+func respondText(w http.ResponseWriter, req *http.Request, val interface{}) int {
+	w.Header().Set("Content-Type", ContentTypeText+"; charset=utf-8")
+	var buffer bytes.Buffer
+	writeText(&buffer, val)
+	w.Write(buffer.Bytes())
+	return buffer.Len()
+}
+
+func respondMarkdown(w http.ResponseWriter, req *http.Request, val interface{}) int {
+	w.Header().Set("Content-Type", ContentTypeMarkdown+"; charset=utf-8")
+	var buffer bytes.Buffer
+	writeMarkdown(&buffer, val)
+	w.Write(buffer.Bytes())
+	return buffer.Len()
+}
@@ -0,0 +1,77 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jolestar/go-probe/pkg/probe"
+	"github.com/stretchr/testify/assert"
+)
+
+func textResult() *probe.Result {
+	result := probe.NewResult("disk-usage")
+	result.Summary = "Mounts: 1"
+	result.Duration = "1ms"
+	result.Set("mounts", []interface{}{map[string]interface{}{"Path": "/a|b", "Free": 10}})
+	result.AddUnit("mounts.Free", probe.UnitBytes)
+	return result
+}
+
+func TestWriteText(t *testing.T) {
+	var buffer bytes.Buffer
+	writeText(&buffer, textResult())
+	assert.Equal(t, `== disk-usage ==
+status    ok
+duration  1ms
+summary   Mounts: 1
+
+mounts.0.Free  10 bytes
+mounts.0.Path  /a|b
+`, buffer.String())
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var buffer bytes.Buffer
+	writeMarkdown(&buffer, &probe.Report{Status: probe.ReportOK, Results: []*probe.Result{textResult()}})
+	assert.Equal(t, `**Status:** ok
+
+| Probe | Status | Duration | Error |
+| --- | --- | --- | --- |
+| disk-usage | ok | 1ms |  |
+
+## disk-usage
+
+**Status:** ok, **Duration:** 1ms
+
+Mounts: 1
+
+| Key | Value |
+| --- | --- |
+| mounts.0.Free | 10 bytes |
+| mounts.0.Path | /a\|b |
+`, buffer.String())
+}
+
+func TestRespondCSVAndNDJSON(t *testing.T) {
+	failed := probe.NewErrorResult("cgroup", errors.New("no cgroup"))
+	report := &probe.Report{Status: probe.ReportPartial, Failed: 1, Results: []*probe.Result{failed, textResult()}}
+	req := httptest.NewRequest("GET", "/?format=csv", nil)
+
+	w := httptest.NewRecorder()
+	respondCSV(w, req, report)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `probe,key,value,unit
+cgroup,error,no cgroup,
+disk-usage,mounts.0.Free,10,bytes
+disk-usage,mounts.0.Path,/a|b,
+`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	respondNDJSON(w, req, report)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[1], `{"name":"disk-usage","status":"ok"`))
+}
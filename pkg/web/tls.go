@@ -0,0 +1,222 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes, at most, as handshakes come in.
+const certCheckInterval = 10 * time.Second
+
+// TLSConfig enables HTTPS, with a key pair from disk or a self-signed
+// certificate generated at startup.
+type TLSConfig struct {
+	Cert       string `yaml:"cert"`
+	Key        string `yaml:"key"`
+	SelfSigned bool   `yaml:"self_signed"`
+	// ClientAuth is optional, verifying certificates given against
+	// auth.client_ca, or require.
+	ClientAuth string `yaml:"client_auth"`
+	// MinVersion is 1.0 to 1.3, 1.2 when empty.
+	MinVersion string `yaml:"min_version"`
+	// Ciphers are the names of the cipher suites of TLS 1.2 and below, the
+	// Go defaults when empty.
+	Ciphers []string `yaml:"ciphers"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.Cert != "" || c.Key != "" || c.SelfSigned
+}
+
+func (c TLSConfig) validate() []string {
+	var errs []string
+	if (c.Cert == "") != (c.Key == "") {
+		errs = append(errs, "tls: cert and key go together")
+	}
+	if c.Cert != "" && c.SelfSigned {
+		errs = append(errs, "tls: self_signed excludes cert and key")
+	}
+	if c.ClientAuth != "" && c.ClientAuth != "optional" && c.ClientAuth != "require" {
+		errs = append(errs, fmt.Sprintf("tls.client_auth: unknown mode [%s]", c.ClientAuth))
+	}
+	if _, ok := tlsVersions[c.MinVersion]; c.MinVersion != "" && !ok {
+		errs = append(errs, fmt.Sprintf("tls.min_version: unknown version [%s]", c.MinVersion))
+	}
+	if _, err := cipherSuites(c.Ciphers); err != nil {
+		errs = append(errs, fmt.Sprintf("tls.ciphers: %s", err.Error()))
+	}
+	return errs
+}
+
+func cipherSuites(names []string) ([]uint16, error) {
+	suites := map[string]uint16{}
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite [%s]", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// newTLSConfig builds the server side TLS config of c, which verifies client
+// certificates against the PEM file caFile when set. selfSigned returns the
+// generated certificate, so it survives reloads.
+func newTLSConfig(c TLSConfig, caFile string, selfSigned func() (*tls.Certificate, error)) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, NextProtos: []string{"h2", "http/1.1"}}
+	if c.MinVersion != "" {
+		config.MinVersion = tlsVersions[c.MinVersion]
+	}
+	ciphers, err := cipherSuites(c.Ciphers)
+	if err != nil {
+		return nil, err
+	}
+	config.CipherSuites = ciphers
+	if c.SelfSigned {
+		cert, err := selfSigned()
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{*cert}
+	} else {
+		reloader, err := newCertReloader(c.Cert, c.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.GetCertificate = reloader.GetCertificate
+	}
+	if caFile != "" {
+		b, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in [%s]", caFile)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if c.ClientAuth == "require" {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return config, nil
+}
+
+// certReloader serves the key pair of two files, loading it again when
+// either changes, as a rotated Kubernetes secret does. A pair which fails to
+// load is logged and the previous one kept.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	lock     sync.Mutex
+	cert     *tls.Certificate
+	stamp    string
+	checked  time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// fileStamp identifies the current content of the files by their
+// modification time and size.
+func (r *certReloader) fileStamp() string {
+	var stamp []string
+	for _, name := range []string{r.certFile, r.keyFile} {
+		if info, err := os.Stat(name); err == nil {
+			stamp = append(stamp, fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size()))
+		}
+	}
+	return strings.Join(stamp, ",")
+}
+
+func (r *certReloader) load() error {
+	stamp := r.fileStamp()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.stamp = stamp
+	return nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if time.Since(r.checked) >= certCheckInterval {
+		r.checked = time.Now()
+		if r.fileStamp() != r.stamp {
+			if err := r.load(); err != nil {
+				log.Printf("Reload certificate %s failed, keeping the current one: %s\n", r.certFile, err.Error())
+			} else {
+				log.Printf("Reloaded certificate %s\n", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// generateSelfSigned returns an ECDSA certificate valid for a year for the
+// host name, localhost and the loopback addresses.
+func generateSelfSigned() (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	names := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "localhost" {
+		names = append(names, hostname)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: names[len(names)-1], Organization: []string{"go-probe self-signed"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     names,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Generated a self-signed certificate for %s, sha256 fingerprint %x\n", strings.Join(names, ", "), sha256.Sum256(der))
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
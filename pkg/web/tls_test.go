@@ -0,0 +1,118 @@
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeKeyPair writes cert as PEM files in dir.
+func writeKeyPair(t *testing.T, dir string, cert *tls.Certificate) (string, string) {
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	assert.NoError(t, err)
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600))
+	return certFile, keyFile
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-probe-tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	first, err := generateSelfSigned()
+	assert.NoError(t, err)
+	certFile, keyFile := writeKeyPair(t, dir, first)
+	reloader, err := newCertReloader(certFile, keyFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	cert, _ := reloader.GetCertificate(nil)
+	assert.Equal(t, first.Certificate[0], cert.Certificate[0])
+
+	second, err := generateSelfSigned()
+	assert.NoError(t, err)
+	writeKeyPair(t, dir, second)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	cert, _ = reloader.GetCertificate(nil)
+	assert.Equal(t, first.Certificate[0], cert.Certificate[0], "checked again before certCheckInterval")
+	reloader.checked = time.Time{}
+	cert, _ = reloader.GetCertificate(nil)
+	assert.Equal(t, second.Certificate[0], cert.Certificate[0])
+
+	// A half written pair keeps the current certificate.
+	assert.NoError(t, ioutil.WriteFile(keyFile, []byte("garbage"), 0600))
+	reloader.checked = time.Time{}
+	cert, _ = reloader.GetCertificate(nil)
+	assert.Equal(t, second.Certificate[0], cert.Certificate[0])
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	config := DefaultConfig()
+	config.TLS = TLSConfig{Cert: "tls.crt", SelfSigned: true, ClientAuth: "always", MinVersion: "1.4",
+		Ciphers: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_NOPE"}}
+	err := config.Validate()
+	if assert.Error(t, err) {
+		for _, expected := range []string{"cert and key go together", "self_signed excludes", "unknown mode [always]",
+			"unknown version [1.4]", "unknown cipher suite [TLS_NOPE]"} {
+			assert.True(t, strings.Contains(err.Error(), expected), expected)
+		}
+	}
+
+	config = DefaultConfig()
+	config.Auth.ClientCA = "ca.pem"
+	err = config.Validate()
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "client certificates need tls"))
+	}
+
+	config = DefaultConfig()
+	config.TLS = TLSConfig{SelfSigned: true, MinVersion: "1.3"}
+	assert.NoError(t, config.Validate())
+	tlsConfig, err := newTLSConfig(config.TLS, "", generateSelfSigned)
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+		assert.Len(t, tlsConfig.Certificates, 1)
+		assert.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+	}
+}
+
+func TestReloadKeepsTLS(t *testing.T) {
+	f := newTestFrame(t, func(config *Config) {
+		config.Listeners = []ListenerConfig{{Address: "127.0.0.1:0", TLS: true}}
+		config.TLS = TLSConfig{SelfSigned: true}
+	})
+	l := f.currentConfig().Listeners[0]
+	ln, err := f.listen(l)
+	if !assert.NoError(t, err) {
+		return
+	}
+	server := f.newServer(l)
+	go server.ServeTLS(ln, "", "")
+	defer server.Close()
+
+	handshake := func() error {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	assert.NoError(t, handshake())
+
+	// TLS turned off only applies on restart, the listener keeps serving
+	// HTTPS with the current settings.
+	assert.NoError(t, f.Reload(DefaultConfig()))
+	assert.NoError(t, handshake())
+}